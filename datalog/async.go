@@ -0,0 +1,270 @@
+package datalog
+
+import (
+	"context"
+	"errors"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"github.com/LabKiko/kiko-gokit/logger"
+)
+
+const (
+	defaultAsyncQueueSize = 1024
+	defaultAsyncWorkers   = 1
+	defaultAsyncBatchSize = 1
+)
+
+// ErrQueueFull is returned by Write when the queue of an asynchronous
+// exporter is full and its overflow policy drops the event.
+var ErrQueueFull = errors.New("datalog: exporter queue full")
+
+// ErrExporterClosed is returned by Write once the asynchronous exporter is closed.
+var ErrExporterClosed = errors.New("datalog: exporter closed")
+
+// OverflowPolicy 异步队列满时的处理方式
+type OverflowPolicy int8
+
+const (
+	// OverflowDrop drops the new event and returns ErrQueueFull.
+	OverflowDrop OverflowPolicy = iota
+	// OverflowDropOldest drops the oldest queued event to make room.
+	OverflowDropOldest
+	// OverflowBlock blocks Write until there is room or its context is done.
+	OverflowBlock
+)
+
+// AsyncConfig configures the asynchronous export of the events, see WithAsync.
+type AsyncConfig struct {
+	// QueueSize is the number of events queued per exporter, default 1024.
+	QueueSize int
+	// Workers is the number of goroutines writing to each exporter, default 1.
+	Workers int
+	// BatchSize is the maximum number of events passed at once to a
+	// BatchExporter, default 1.
+	BatchSize int
+	// BatchTimeout is how long a worker waits to fill a batch, zero only
+	// takes the events already queued.
+	BatchTimeout time.Duration
+	// Overflow is the policy applied when a queue is full, default OverflowDrop.
+	Overflow OverflowPolicy
+}
+
+// Record is an event queued for an exporter.
+type Record struct {
+	Ctx      context.Context
+	Event    *Event
+	Metadata Metadata
+}
+
+// BatchExporter is implemented by the exporters writing several events at
+// once, they are then passed up to AsyncConfig.BatchSize events.
+type BatchExporter interface {
+	Exporter
+	WriteBatch(records []*Record) error
+}
+
+// asyncExporter queues the events and writes them to an exporter from a
+// pool of workers.
+type asyncExporter struct {
+	exporter Exporter
+	config   AsyncConfig
+
+	mutex   sync.Mutex
+	cond    *sync.Cond // signaled when pending decreases
+	queue   chan *Record
+	pending int // queued or being written
+	closed  bool
+	workers sync.WaitGroup
+}
+
+func newAsyncExporter(exporter Exporter, config AsyncConfig) *asyncExporter {
+	if config.QueueSize <= 0 {
+		config.QueueSize = defaultAsyncQueueSize
+	}
+	if config.Workers <= 0 {
+		config.Workers = defaultAsyncWorkers
+	}
+	if config.BatchSize <= 0 {
+		config.BatchSize = defaultAsyncBatchSize
+	}
+
+	e := &asyncExporter{
+		exporter: exporter,
+		config:   config,
+		queue:    make(chan *Record, config.QueueSize),
+	}
+	e.cond = sync.NewCond(&e.mutex)
+	e.workers.Add(config.Workers)
+	for i := 0; i < config.Workers; i++ {
+		go e.work()
+	}
+	return e
+}
+
+func (e *asyncExporter) Name() string { return exporterName(e.exporter) }
+
+// Write queues a copy of the event, the context is detached from the
+// cancellation of ctx as the event outlives the call.
+func (e *asyncExporter) Write(ctx context.Context, event *Event, metadata Metadata) error {
+	ev := *event
+	record := &Record{Ctx: detachedContext{ctx}, Event: &ev, Metadata: metadata}
+
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	for {
+		if e.closed {
+			return ErrExporterClosed
+		}
+		select {
+		case e.queue <- record:
+			e.pending++
+			return nil
+		default:
+		}
+
+		switch e.config.Overflow {
+		case OverflowDropOldest:
+			select {
+			case <-e.queue:
+				e.pending--
+				logger.Warnf("datalog queue full, drop the oldest event")
+			default:
+			}
+		case OverflowBlock:
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			// Woken up when a worker takes an event, or by the timer to
+			// observe the cancellation of ctx.
+			timer := time.AfterFunc(10*time.Millisecond, e.cond.Broadcast)
+			e.cond.Wait()
+			timer.Stop()
+		default:
+			return ErrQueueFull
+		}
+	}
+}
+
+func (e *asyncExporter) work() {
+	defer e.workers.Done()
+
+	batch := make([]*Record, 0, e.config.BatchSize)
+	for record := range e.queue {
+		batch = append(batch[:0], record)
+		batch = e.fill(batch)
+		e.mutex.Lock()
+		// There is room in the queue for the blocked writers.
+		e.cond.Broadcast()
+		e.mutex.Unlock()
+
+		e.write(batch)
+
+		e.mutex.Lock()
+		e.pending -= len(batch)
+		e.cond.Broadcast()
+		e.mutex.Unlock()
+	}
+}
+
+// fill adds the queued events to batch, waiting up to BatchTimeout.
+func (e *asyncExporter) fill(batch []*Record) []*Record {
+	var timeout <-chan time.Time
+	if e.config.BatchTimeout > 0 && len(batch) < e.config.BatchSize {
+		timer := time.NewTimer(e.config.BatchTimeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	for len(batch) < e.config.BatchSize {
+		select {
+		case record, ok := <-e.queue:
+			if !ok {
+				return batch
+			}
+			batch = append(batch, record)
+			continue
+		default:
+		}
+		if timeout == nil {
+			return batch
+		}
+		select {
+		case record, ok := <-e.queue:
+			if !ok {
+				return batch
+			}
+			batch = append(batch, record)
+		case <-timeout:
+			return batch
+		}
+	}
+	return batch
+}
+
+func (e *asyncExporter) write(batch []*Record) {
+	defer func() {
+		if p := recover(); p != nil {
+			logger.Errorf("datalog exporter %s panic: %v\n%s", e.Name(), p, debug.Stack())
+		}
+	}()
+
+	if exporter, ok := e.exporter.(BatchExporter); ok && e.config.BatchSize > 1 {
+		if err := exporter.WriteBatch(batch); err != nil {
+			logger.Errorf("datalog write %d events error: %v", len(batch), err)
+		}
+		return
+	}
+	for _, record := range batch {
+		if err := e.exporter.Write(record.Ctx, record.Event, record.Metadata); err != nil {
+			logger.Errorf("datalog write event %s error: %v", record.Event.Name, err)
+		}
+	}
+}
+
+// Flush waits until the queue is empty and the events being written are
+// written, then flushes the exporter.
+func (e *asyncExporter) Flush() error {
+	e.mutex.Lock()
+	for e.pending > 0 {
+		e.cond.Wait()
+	}
+	e.mutex.Unlock()
+
+	return e.exporter.Flush()
+}
+
+// Close stops accepting events, writes the queued ones, then flushes and
+// closes the exporter.
+func (e *asyncExporter) Close() error {
+	e.mutex.Lock()
+	if e.closed {
+		e.mutex.Unlock()
+		return nil
+	}
+	e.closed = true
+	close(e.queue)
+	e.cond.Broadcast()
+	e.mutex.Unlock()
+
+	e.workers.Wait()
+	err := e.exporter.Flush()
+	if closeErr := e.exporter.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// detachedContext keeps the values of a context without its deadline and
+// cancellation.
+type detachedContext struct {
+	parent context.Context
+}
+
+func (c detachedContext) Deadline() (time.Time, bool) { return time.Time{}, false }
+
+func (c detachedContext) Done() <-chan struct{} { return nil }
+
+func (c detachedContext) Err() error { return nil }
+
+func (c detachedContext) Value(key interface{}) interface{} { return c.parent.Value(key) }
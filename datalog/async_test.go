@@ -0,0 +1,171 @@
+package datalog
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// blockingExporter blocks the writes until release is closed.
+type blockingExporter struct {
+	recordExporter
+	release chan struct{}
+}
+
+func (e *blockingExporter) Write(ctx context.Context, event *Event, metadata Metadata) error {
+	<-e.release
+	return e.recordExporter.Write(ctx, event, metadata)
+}
+
+// batchExporter records the size of the batches written.
+type batchExporter struct {
+	recordExporter
+	mutex   sync.Mutex
+	batches []int
+}
+
+func (e *batchExporter) WriteBatch(records []*Record) error {
+	e.mutex.Lock()
+	e.batches = append(e.batches, len(records))
+	e.mutex.Unlock()
+	for _, record := range records {
+		_ = e.recordExporter.Write(record.Ctx, record.Event, record.Metadata)
+	}
+	return nil
+}
+
+func TestAsyncExporter_Flush(t *testing.T) {
+	exporter := &blockingExporter{release: make(chan struct{})}
+	async := newAsyncExporter(exporter, AsyncConfig{Workers: 2})
+	defer async.Close()
+
+	for i := 0; i < 10; i++ {
+		// The slow exporter does not block Write.
+		assert.Nil(t, async.Write(context.Background(), &Event{Name: "login"}, Metadata{"i": i}))
+	}
+	close(exporter.release)
+	assert.Nil(t, async.Flush())
+	assert.Len(t, exporter.metadata, 10)
+}
+
+func TestAsyncExporter_EventCopy(t *testing.T) {
+	exporter := &blockingExporter{release: make(chan struct{})}
+	async := newAsyncExporter(exporter, AsyncConfig{})
+	defer async.Close()
+
+	// The caller reuses its event once Write returned.
+	event := &Event{Name: "login"}
+	assert.Nil(t, async.Write(context.Background(), event, Metadata{}))
+	event.Name = "logout"
+	assert.Nil(t, async.Write(context.Background(), event, Metadata{}))
+
+	close(exporter.release)
+	assert.Nil(t, async.Flush())
+	assert.Equal(t, "login", exporter.events[0].Name)
+	assert.Equal(t, "logout", exporter.events[1].Name)
+}
+
+func TestAsyncExporter_Close(t *testing.T) {
+	exporter := &recordExporter{}
+	async := newAsyncExporter(exporter, AsyncConfig{})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	for i := 0; i < 10; i++ {
+		assert.Nil(t, async.Write(ctx, &Event{Name: "login"}, Metadata{"i": i}))
+	}
+	cancel()
+	assert.Nil(t, async.Close())
+	assert.Len(t, exporter.metadata, 10)
+	assert.Equal(t, ErrExporterClosed, async.Write(context.Background(), &Event{Name: "login"}, Metadata{}))
+}
+
+func TestAsyncExporter_Overflow(t *testing.T) {
+	event := &Event{Name: "login"}
+
+	t.Run("drop", func(t *testing.T) {
+		exporter := &blockingExporter{release: make(chan struct{})}
+		async := newAsyncExporter(exporter, AsyncConfig{QueueSize: 1})
+		defer async.Close()
+
+		// The first event is taken by the worker, the second one queued.
+		assert.Nil(t, async.Write(context.Background(), event, Metadata{"i": 0}))
+		assert.Eventually(t, func() bool { return len(async.queue) == 0 }, time.Second, time.Millisecond)
+		assert.Nil(t, async.Write(context.Background(), event, Metadata{"i": 1}))
+		assert.Equal(t, ErrQueueFull, async.Write(context.Background(), event, Metadata{"i": 2}))
+
+		close(exporter.release)
+		assert.Nil(t, async.Flush())
+		assert.Len(t, exporter.metadata, 2)
+	})
+
+	t.Run("drop oldest", func(t *testing.T) {
+		exporter := &blockingExporter{release: make(chan struct{})}
+		async := newAsyncExporter(exporter, AsyncConfig{QueueSize: 1, Overflow: OverflowDropOldest})
+		defer async.Close()
+
+		assert.Nil(t, async.Write(context.Background(), event, Metadata{"i": 0}))
+		assert.Eventually(t, func() bool { return len(async.queue) == 0 }, time.Second, time.Millisecond)
+		assert.Nil(t, async.Write(context.Background(), event, Metadata{"i": 1}))
+		assert.Nil(t, async.Write(context.Background(), event, Metadata{"i": 2}))
+
+		close(exporter.release)
+		assert.Nil(t, async.Flush())
+		assert.Equal(t, []Metadata{{"i": 0}, {"i": 2}}, exporter.metadata)
+	})
+
+	t.Run("block", func(t *testing.T) {
+		exporter := &blockingExporter{release: make(chan struct{})}
+		async := newAsyncExporter(exporter, AsyncConfig{QueueSize: 1, Overflow: OverflowBlock})
+		defer async.Close()
+
+		assert.Nil(t, async.Write(context.Background(), event, Metadata{"i": 0}))
+		assert.Eventually(t, func() bool { return len(async.queue) == 0 }, time.Second, time.Millisecond)
+		assert.Nil(t, async.Write(context.Background(), event, Metadata{"i": 1}))
+
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		assert.Equal(t, context.DeadlineExceeded, async.Write(ctx, event, Metadata{"i": 2}))
+
+		time.AfterFunc(50*time.Millisecond, func() { close(exporter.release) })
+		assert.Nil(t, async.Write(context.Background(), event, Metadata{"i": 3}))
+		assert.Nil(t, async.Flush())
+		assert.Len(t, exporter.metadata, 3)
+	})
+}
+
+func TestAsyncExporter_Batch(t *testing.T) {
+	exporter := &batchExporter{}
+	async := newAsyncExporter(exporter, AsyncConfig{BatchSize: 4, BatchTimeout: 100 * time.Millisecond})
+
+	for i := 0; i < 10; i++ {
+		assert.Nil(t, async.Write(context.Background(), &Event{Name: "login"}, Metadata{"i": i}))
+	}
+	assert.Nil(t, async.Close())
+	assert.Len(t, exporter.metadata, 10)
+
+	total := 0
+	for _, size := range exporter.batches {
+		assert.LessOrEqual(t, size, 4)
+		total += size
+	}
+	assert.Equal(t, 10, total)
+	assert.Less(t, len(exporter.batches), 10)
+}
+
+func TestDatalogProvider_Async(t *testing.T) {
+	exporter := &blockingExporter{release: make(chan struct{})}
+	provider := &datalogProvider{
+		opts:      &config{appId: "app"},
+		exporters: []Exporter{newAsyncExporter(exporter, AsyncConfig{})},
+	}
+
+	assert.Nil(t, provider.Write(context.Background(), &Event{Name: "login", DistinctId: "1"}))
+	assert.Len(t, exporter.metadata, 0)
+	close(exporter.release)
+	assert.Nil(t, provider.Close())
+	assert.Len(t, exporter.metadata, 1)
+	assert.Equal(t, "app", exporter.metadata[0]["app_id"])
+}
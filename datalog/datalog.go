@@ -30,7 +30,10 @@ type datalogProvider struct {
 }
 
 func Dial(appId string, options ...Option) (DataLog, error) {
-	cfg := defaultOption
+	// The options are applied to a copy, so that they do not leak into the
+	// following Dial.
+	opts := *defaultOption
+	cfg := &opts
 	cfg.appId = appId
 
 	for _, opt := range options {
@@ -39,7 +42,7 @@ func Dial(appId string, options ...Option) (DataLog, error) {
 
 	c := &datalogProvider{
 		opts:      cfg,
-		exporters: make([]Exporter, 0, 3),
+		exporters: make([]Exporter, 0, 3+len(cfg.exporters)),
 	}
 
 	c.init()
@@ -51,10 +54,36 @@ func (p *datalogProvider) init() {
 	p.loggerExporter()
 	p.kafkaExporter()
 	p.saExporter()
+
+	for _, registered := range p.opts.exporters {
+		if p.enabled(registered.name) {
+			p.register(registered.name, registered.exporter, registered.filters...)
+		}
+	}
+}
+
+func (p *datalogProvider) enabled(name string) bool {
+	return !p.opts.disabled[name]
+}
+
+// register adds exporter, instrumented, filtered by filters and made
+// asynchronous according to the options. The metrics only count the events
+// passing the filters.
+func (p *datalogProvider) register(name string, exporter Exporter, filters ...FilterOption) {
+	exporter = &instrumentedExporter{name: name, exporter: exporter, metrics: p.opts.metrics}
+	if len(filters) > 0 {
+		exporter = NewFilter(exporter, filters...)
+	}
+	if p.opts.async != nil {
+		exporter = newAsyncExporter(exporter, *p.opts.async)
+	}
+	p.exporters = append(p.exporters, exporter)
+
+	logger.Infof("datalog init %s exporter success", name)
 }
 
 func (p *datalogProvider) loggerExporter() {
-	if p.opts.loggerOpts.disable {
+	if p.opts.loggerOpts.disable || !p.enabled(LoggerExporterName) {
 		return
 	}
 
@@ -64,13 +93,11 @@ func (p *datalogProvider) loggerExporter() {
 	}
 
 	// 神策默认过滤一些自定义打点字段
-	p.exporters = append(p.exporters, NewFilter(log, FilterKey("event")))
-
-	logger.Info("datalog init logger exporter success")
+	p.register(LoggerExporterName, log, FilterKey("event"))
 }
 
 func (p *datalogProvider) kafkaExporter() {
-	if len(p.opts.kafkaOpts.brokers) == 0 {
+	if len(p.opts.kafkaOpts.brokers) == 0 || !p.enabled(KafkaExporterName) {
 		return
 	}
 
@@ -79,13 +106,11 @@ func (p *datalogProvider) kafkaExporter() {
 		logger.Fatal(err)
 	}
 
-	p.exporters = append(p.exporters, kafka)
-
-	logger.Info("datalog init kafka exporter success")
+	p.register(KafkaExporterName, kafka)
 }
 
 func (p *datalogProvider) saExporter() {
-	if p.opts.saOpts.serviceName == "" || p.opts.saOpts.token == "" {
+	if p.opts.saOpts.serviceName == "" || p.opts.saOpts.token == "" || !p.enabled(SensorsDataExporterName) {
 		return
 	}
 
@@ -95,12 +120,17 @@ func (p *datalogProvider) saExporter() {
 	}
 
 	// 神策默认过滤一些自定义打点字段
-	p.exporters = append(p.exporters, NewFilter(NewSensorsData(p.opts), filterKeys...))
-
-	logger.Info("datalog init sensorsdata exporter success")
+	p.register(SensorsDataExporterName, NewSensorsData(p.opts), filterKeys...)
 }
 
 func (p *datalogProvider) Write(ctx context.Context, event *Event, attributes ...attribute.KeyValue) error {
+	if p.opts.schemas != nil {
+		var err error
+		if attributes, err = p.opts.schemas.apply(p.opts.validation, event, attributes); err != nil {
+			return err
+		}
+	}
+
 	if event.Time.IsZero() {
 		event.Time = time.Now()
 	}
@@ -133,24 +163,33 @@ func (p *datalogProvider) Write(ctx context.Context, event *Event, attributes ..
 		// }
 	}
 
-	var err error
+	// Each exporter reports to its own slot, they are collected once all
+	// returned.
+	errs := make([]error, len(p.exporters))
 	var waitGroup = sync.WaitGroup{}
 	waitGroup.Add(len(p.exporters))
-	for _, exporter := range p.exporters {
-		exp := exporter
-		go func() {
-			defer func() {
-				waitGroup.Done()
-				if r := recover(); r != nil {
-					logger.Errorf("datalog write recover error: %+v", p)
-				}
-			}()
-			err = multierr.Append(err, exp.Write(ctx, event, DeepCopy(metadata)))
-		}()
+	for i, exporter := range p.exporters {
+		go func(i int, exporter Exporter) {
+			defer waitGroup.Done()
+			errs[i] = safeWrite(ctx, exporter, event, DeepCopy(metadata))
+		}(i, exporter)
 	}
 
 	waitGroup.Wait()
 
+	var err *WriteError
+	for i, e := range errs {
+		if e == nil {
+			continue
+		}
+		if err == nil {
+			err = &WriteError{}
+		}
+		err.Errors = append(err.Errors, &ExporterError{Exporter: exporterName(p.exporters[i]), Err: e})
+	}
+	if err == nil {
+		return nil
+	}
 	return err
 }
 
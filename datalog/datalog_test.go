@@ -2,41 +2,46 @@ package datalog
 
 import (
 	"context"
+	"os"
 	"testing"
 	"time"
 
 	"github.com/LabKiko/kiko-gokit/datalog/attribute"
 	"github.com/LabKiko/kiko-gokit/logger"
+	"github.com/Shopify/sarama"
 	"github.com/stretchr/testify/assert"
 )
 
-var datalog DataLog
+// broker is the kafka broker TestDatalogProvider_Write exports to.
+const broker = "10.130.12.10:9092"
 
 func TestMain(m *testing.M) {
-	var (
-		err error
-	)
+	logger.InitDefaultLogger()
 
-	datalog, err = Dial("infra.bff.feeds",
-		WithBrokers([]string{"10.130.12.10:9092"}),
-		WithBasePath("../data"),
+	os.Exit(m.Run())
+}
+
+func TestDatalogProvider_Write(t *testing.T) {
+	cfg := sarama.NewConfig()
+	cfg.Net.DialTimeout = time.Second
+	cfg.Metadata.Retry.Max = 0
+	client, err := sarama.NewClient([]string{broker}, cfg)
+	if err != nil {
+		t.Skipf("kafka broker %s unreachable: %v", broker, err)
+	}
+	_ = client.Close()
+
+	datalog, err := Dial("infra.bff.feeds",
+		WithBrokers([]string{broker}),
+		WithBasePath(t.TempDir()),
 		WithServiceName("fenmiaozhen"),
 		WithProjectName(""),
 		WithToken("e4f744a7b594fbc1"),
 		WithDebug(true),
 	)
-	if err != nil {
-		panic(err)
-	}
-
+	assert.Nil(t, err)
 	defer datalog.Close()
 
-	logger.InitDefaultLogger()
-
-	m.Run()
-}
-
-func TestDatalogProvider_Write(t *testing.T) {
 	attributes := make([]attribute.KeyValue, 0)
 	attributes = append(attributes,
 		attribute.String("biz_system", "sona"),
@@ -44,7 +49,7 @@ func TestDatalogProvider_Write(t *testing.T) {
 		attribute.String("department_id", "1503300425254699008"),
 	)
 
-	err := datalog.Write(context.Background(), &Event{
+	err = datalog.Write(context.Background(), &Event{
 		Name:         "adddepartmentsuccess",
 		DistinctId:   "1387639968637124617",
 		DistinctType: User,
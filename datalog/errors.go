@@ -0,0 +1,77 @@
+package datalog
+
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+	"strings"
+
+	"github.com/LabKiko/kiko-gokit/logger"
+)
+
+// PanicError is the panic of an exporter recovered by Write.
+type PanicError struct {
+	Value interface{}
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("datalog: exporter panic: %v\n%s", e.Value, e.Stack)
+}
+
+// ExporterError is the failure of an exporter to write an event.
+type ExporterError struct {
+	Exporter string
+	Err      error
+}
+
+func (e *ExporterError) Error() string {
+	return fmt.Sprintf("datalog: exporter %s: %v", e.Exporter, e.Err)
+}
+
+func (e *ExporterError) Unwrap() error { return e.Err }
+
+// WriteError is returned by Write when some exporters failed, the other
+// exporters did write the event.
+type WriteError struct {
+	Errors []*ExporterError
+}
+
+func (e *WriteError) Error() string {
+	messages := make([]string, 0, len(e.Errors))
+	for _, err := range e.Errors {
+		messages = append(messages, err.Error())
+	}
+	return strings.Join(messages, "; ")
+}
+
+// Unwrap returns the errors of the exporters for errors.Is and errors.As.
+func (e *WriteError) Unwrap() []error {
+	errs := make([]error, 0, len(e.Errors))
+	for _, err := range e.Errors {
+		errs = append(errs, err)
+	}
+	return errs
+}
+
+// Err returns the error of the exporter named name, nil if it did write.
+func (e *WriteError) Err(name string) error {
+	for _, err := range e.Errors {
+		if err.Exporter == name {
+			return err.Err
+		}
+	}
+	return nil
+}
+
+// safeWrite writes the event to exporter, a panic is returned as a *PanicError.
+func safeWrite(ctx context.Context, exporter Exporter, event *Event, metadata Metadata) (err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			stack := debug.Stack()
+			logger.Errorf("datalog exporter %s panic: %v\n%s", exporterName(exporter), p, stack)
+			err = &PanicError{Value: p, Stack: stack}
+		}
+	}()
+	return exporter.Write(ctx, event, metadata)
+}
@@ -0,0 +1,86 @@
+package datalog
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/LabKiko/kiko-gokit/datalog/attribute"
+	"github.com/stretchr/testify/assert"
+)
+
+// panicExporter panics on every write.
+type panicExporter struct {
+	recordExporter
+}
+
+func (e *panicExporter) Name() string { return "panic" }
+
+func (e *panicExporter) Write(ctx context.Context, event *Event, metadata Metadata) error {
+	panic("exporter bug")
+}
+
+func TestDatalogProvider_WriteError(t *testing.T) {
+	unavailable := errors.New("unavailable")
+	ok := &namedExporter{name: "ok"}
+	provider := &datalogProvider{opts: &config{appId: "app"}, exporters: []Exporter{
+		ok,
+		&namedExporter{name: "failing", err: unavailable},
+		&panicExporter{},
+	}}
+
+	err := provider.Write(context.Background(), &Event{Name: "login", DistinctId: "1"})
+	var writeErr *WriteError
+	assert.True(t, errors.As(err, &writeErr))
+	assert.Len(t, writeErr.Errors, 2)
+	assert.Equal(t, "failing", writeErr.Errors[0].Exporter)
+	assert.Equal(t, "panic", writeErr.Errors[1].Exporter)
+	assert.True(t, errors.Is(err, unavailable))
+	assert.Nil(t, writeErr.Err("ok"))
+	assert.Equal(t, unavailable, writeErr.Err("failing"))
+
+	var panicErr *PanicError
+	assert.True(t, errors.As(err, &panicErr))
+	assert.Equal(t, "exporter bug", panicErr.Value)
+	assert.Contains(t, string(panicErr.Stack), "panicExporter")
+	assert.Len(t, ok.metadata, 1)
+
+	provider.exporters = provider.exporters[:1]
+	assert.Nil(t, provider.Write(context.Background(), &Event{Name: "login", DistinctId: "1"}))
+}
+
+func TestDatalogProvider_WriteConcurrent(t *testing.T) {
+	exporters := make([]Exporter, 0, 8)
+	for i := 0; i < cap(exporters); i++ {
+		exporter := &namedExporter{name: fmt.Sprintf("exporter-%d", i)}
+		if i%2 == 0 {
+			exporter.err = errors.New("unavailable")
+		}
+		exporters = append(exporters, exporter)
+	}
+	provider := &datalogProvider{opts: &config{appId: "app"}, exporters: exporters}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				err := provider.Write(context.Background(), &Event{Name: "login", DistinctId: "1"}, attribute.Int("j", j))
+				var writeErr *WriteError
+				if assert.True(t, errors.As(err, &writeErr)) {
+					assert.Len(t, writeErr.Errors, 4)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	for i, exporter := range exporters {
+		if i%2 == 1 {
+			assert.Len(t, exporter.(*namedExporter).metadata, 160)
+		}
+	}
+}
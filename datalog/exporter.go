@@ -0,0 +1,81 @@
+package datalog
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.uber.org/multierr"
+)
+
+// The names of the built-in exporters, see WithExporterDisable.
+const (
+	LoggerExporterName      = "logger"
+	KafkaExporterName       = "kafka"
+	SensorsDataExporterName = "sensorsdata"
+)
+
+// NamedExporter is implemented by the exporters naming themselves, the name
+// labels their metrics and can be passed to WithExporterDisable. The other
+// exporters are named after their type.
+type NamedExporter interface {
+	Exporter
+	Name() string
+}
+
+// exporterName returns the name of exporter.
+func exporterName(exporter Exporter) string {
+	if named, ok := exporter.(NamedExporter); ok {
+		return named.Name()
+	}
+	return fmt.Sprintf("%T", exporter)
+}
+
+// registeredExporter is an exporter added by WithExporter.
+type registeredExporter struct {
+	name     string
+	exporter Exporter
+	filters  []FilterOption
+}
+
+// instrumentedExporter names an exporter and records the metrics of its writes.
+type instrumentedExporter struct {
+	name     string
+	exporter Exporter
+	metrics  *Metrics
+}
+
+func (e *instrumentedExporter) Name() string { return e.name }
+
+func (e *instrumentedExporter) Write(ctx context.Context, event *Event, metadata Metadata) error {
+	start := time.Now()
+	err := e.exporter.Write(ctx, event, metadata)
+	e.metrics.observeWrite(e.name, 1, time.Since(start), err)
+	return err
+}
+
+// WriteBatch writes the records at once when the exporter is a
+// BatchExporter, one by one otherwise.
+func (e *instrumentedExporter) WriteBatch(records []*Record) error {
+	batch, ok := e.exporter.(BatchExporter)
+	if !ok {
+		var err error
+		for _, record := range records {
+			err = multierr.Append(err, e.Write(record.Ctx, record.Event, record.Metadata))
+		}
+		return err
+	}
+
+	start := time.Now()
+	err := batch.WriteBatch(records)
+	e.metrics.observeWrite(e.name, len(records), time.Since(start), err)
+	return err
+}
+
+func (e *instrumentedExporter) Flush() error {
+	return e.exporter.Flush()
+}
+
+func (e *instrumentedExporter) Close() error {
+	return e.exporter.Close()
+}
@@ -0,0 +1,111 @@
+package datalog
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/LabKiko/kiko-gokit/datalog/attribute"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+)
+
+// namedExporter is a recordExporter with a name, failing when err is set.
+type namedExporter struct {
+	batchExporter
+	name string
+	err  error
+}
+
+func (e *namedExporter) Name() string { return e.name }
+
+func (e *namedExporter) Write(ctx context.Context, event *Event, metadata Metadata) error {
+	if e.err != nil {
+		return e.err
+	}
+	return e.batchExporter.Write(ctx, event, metadata)
+}
+
+func gatherValue(t *testing.T, registry *prometheus.Registry, name, exporter string) float64 {
+	families, err := registry.Gather()
+	assert.Nil(t, err)
+	for _, family := range families {
+		if family.GetName() != name {
+			continue
+		}
+		for _, m := range family.GetMetric() {
+			if m.GetLabel()[0].GetValue() != exporter {
+				continue
+			}
+			if m.GetCounter() != nil {
+				return m.GetCounter().GetValue()
+			}
+			return float64(m.GetHistogram().GetSampleCount())
+		}
+	}
+	return -1
+}
+
+func TestWithExporter(t *testing.T) {
+	http := &namedExporter{name: "http"}
+	clickhouse := &namedExporter{name: "clickhouse"}
+	failing := &namedExporter{name: "failing", err: errors.New("unavailable")}
+	registry := prometheus.NewRegistry()
+
+	datalog, err := Dial("app",
+		WithLogDisable(true),
+		WithExporter(http, FilterKey("team_id")),
+		WithExporter(clickhouse),
+		WithExporter(failing),
+		WithExporterDisable("clickhouse", true),
+		WithMetrics(NewPrometheusMetrics(registry)),
+	)
+	assert.Nil(t, err)
+	assert.Len(t, datalog.(*datalogProvider).exporters, 2)
+
+	err = datalog.Write(context.Background(), &Event{Name: "login", DistinctId: "1"})
+	assert.NotNil(t, err)
+	assert.Nil(t, datalog.Close())
+
+	assert.Len(t, http.metadata, 1)
+	assert.Len(t, clickhouse.metadata, 0)
+	assert.Equal(t, float64(1), gatherValue(t, registry, "datalog_exporter_events_written_total", "http"))
+	assert.Equal(t, float64(1), gatherValue(t, registry, "datalog_exporter_events_failed_total", "failing"))
+	assert.Equal(t, float64(1), gatherValue(t, registry, "datalog_exporter_write_duration_seconds", "http"))
+
+	// The exporters do not leak into the following Dial.
+	datalog, err = Dial("app", WithLogDisable(true))
+	assert.Nil(t, err)
+	assert.Len(t, datalog.(*datalogProvider).exporters, 0)
+}
+
+func TestWithExporter_Batch(t *testing.T) {
+	exporter := &namedExporter{name: "clickhouse"}
+	registry := prometheus.NewRegistry()
+
+	datalog, err := Dial("app",
+		WithLogDisable(true),
+		WithExporter(exporter, FilterKey("team_id"), FilterFunc(func(ctx context.Context, event *Event, metadata Metadata) bool {
+			return event.Name == "logout"
+		})),
+		WithAsync(AsyncConfig{BatchSize: 10}),
+		WithMetrics(NewPrometheusMetrics(registry)),
+	)
+	assert.Nil(t, err)
+
+	for _, name := range []string{"login", "logout", "login"} {
+		assert.Nil(t, datalog.Write(context.Background(), &Event{Name: name, DistinctId: "1"}, attribute.String("team_id", "1")))
+	}
+	assert.Nil(t, datalog.Close())
+
+	assert.Len(t, exporter.metadata, 2)
+	for _, metadata := range exporter.metadata {
+		assert.NotContains(t, metadata, "team_id")
+	}
+	total := 0
+	for _, size := range exporter.batches {
+		total += size
+	}
+	assert.Equal(t, 2, total)
+	assert.Equal(t, float64(2), gatherValue(t, registry, "datalog_exporter_events_written_total", "clickhouse"))
+}
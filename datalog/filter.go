@@ -2,6 +2,8 @@ package datalog
 
 import (
 	"context"
+
+	"go.uber.org/multierr"
 )
 
 // FilterOption is filter option.
@@ -53,6 +55,9 @@ func NewFilter(exporter Exporter, opts ...FilterOption) *Filter {
 	return &options
 }
 
+// Name is the name of the exporter filtered.
+func (f *Filter) Name() string { return exporterName(f.exporter) }
+
 func (f *Filter) Write(ctx context.Context, event *Event, metadata Metadata) error {
 	if f.filter != nil && f.filter(ctx, event, metadata) {
 		return nil
@@ -69,6 +74,36 @@ func (f *Filter) Write(ctx context.Context, event *Event, metadata Metadata) err
 	return f.exporter.Write(ctx, event, metadata)
 }
 
+// WriteBatch filters the records, then writes them at once when the
+// exporter is a BatchExporter, one by one otherwise.
+func (f *Filter) WriteBatch(records []*Record) error {
+	batch, ok := f.exporter.(BatchExporter)
+	if !ok {
+		var err error
+		for _, record := range records {
+			err = multierr.Append(err, f.Write(record.Ctx, record.Event, record.Metadata))
+		}
+		return err
+	}
+
+	filtered := make([]*Record, 0, len(records))
+	for _, record := range records {
+		if f.filter != nil && f.filter(record.Ctx, record.Event, record.Metadata) {
+			continue
+		}
+		for k := range record.Metadata {
+			if _, ok := f.key[k]; ok {
+				delete(record.Metadata, k)
+			}
+		}
+		filtered = append(filtered, record)
+	}
+	if len(filtered) == 0 {
+		return nil
+	}
+	return batch.WriteBatch(filtered)
+}
+
 func (f *Filter) Flush() error {
 	return f.exporter.Flush()
 }
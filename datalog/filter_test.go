@@ -16,7 +16,7 @@ func TestFilterAll(t *testing.T) {
 			"instance_id": "JeffreyBool",
 		},
 		loggerOpts: loggerOpts{
-			path:          "../data",
+			path:          t.TempDir(),
 			encoderConfig: defaultOption.loggerOpts.encoderConfig,
 		},
 	}
@@ -52,7 +52,7 @@ func TestFilterKey(t *testing.T) {
 			"instance_id": "JeffreyBool",
 		},
 		loggerOpts: loggerOpts{
-			path:          "../data",
+			path:          t.TempDir(),
 			encoderConfig: defaultOption.loggerOpts.encoderConfig,
 		},
 	}
@@ -82,7 +82,7 @@ func TestFilterValue(t *testing.T) {
 			"instance_id": "JeffreyBool",
 		},
 		loggerOpts: loggerOpts{
-			path:          "../data",
+			path:          t.TempDir(),
 			encoderConfig: defaultOption.loggerOpts.encoderConfig,
 		},
 	}
@@ -112,7 +112,7 @@ func TestFilterFunc(t *testing.T) {
 			"instance_id": "JeffreyBool",
 		},
 		loggerOpts: loggerOpts{
-			path:          "../data",
+			path:          t.TempDir(),
 			encoderConfig: defaultOption.loggerOpts.encoderConfig,
 		},
 	}
@@ -142,7 +142,7 @@ func BenchmarkFilterKey(b *testing.B) {
 			"instance_id": "JeffreyBool",
 		},
 		loggerOpts: loggerOpts{
-			path:          "../data",
+			path:          b.TempDir(),
 			encoderConfig: defaultOption.loggerOpts.encoderConfig,
 		},
 	}
@@ -179,7 +179,7 @@ func BenchmarkFilterValue(b *testing.B) {
 			"instance_id": "JeffreyBool",
 		},
 		loggerOpts: loggerOpts{
-			path:          "../data",
+			path:          b.TempDir(),
 			encoderConfig: defaultOption.loggerOpts.encoderConfig,
 		},
 	}
@@ -217,7 +217,7 @@ func BenchmarkFilterFunc(b *testing.B) {
 			"instance_id": "JeffreyBool",
 		},
 		loggerOpts: loggerOpts{
-			path:          "../data",
+			path:          b.TempDir(),
 			encoderConfig: defaultOption.loggerOpts.encoderConfig,
 		},
 	}
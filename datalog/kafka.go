@@ -10,18 +10,41 @@ import (
 type Kafka struct {
 	opts   *config
 	writer kafka.Writer
+	admin  kafka.Admin
 }
 
 func NewKafka(opts *config) (*Kafka, error) {
 	cli := &Kafka{
 		opts: opts,
 	}
-	writer, err := kafka.NewWriter(opts.kafkaOpts.brokers)
+	writerOpts := make([]kafka.WriterOpt, 0, 1)
+	if opts.kafkaOpts.topicSpec != nil {
+		admin, err := kafka.NewAdmin(opts.kafkaOpts.brokers)
+		if err != nil {
+			return nil, err
+		}
+		cli.admin = admin
+		writerOpts = append(writerOpts, kafka.WriterInterceptor(kafka.AutoCreateTopics(admin, *opts.kafkaOpts.topicSpec)))
+	}
+	writer, err := kafka.NewWriter(opts.kafkaOpts.brokers, writerOpts...)
 	if err != nil {
+		cli.closeAdmin()
 		return nil, err
 	}
 
 	cli.writer = writer
+	if opts.kafkaOpts.bufferDir != "" {
+		bufferOpts := make([]kafka.BufferOpt, 0, 1)
+		if opts.kafkaOpts.bufferMaxBytes > 0 {
+			bufferOpts = append(bufferOpts, kafka.BufferMaxBytes(opts.kafkaOpts.bufferMaxBytes))
+		}
+		cli.writer, err = kafka.NewBufferedWriter(writer, opts.kafkaOpts.bufferDir, bufferOpts...)
+		if err != nil {
+			_ = writer.Close()
+			cli.closeAdmin()
+			return nil, err
+		}
+	}
 
 	return cli, nil
 }
@@ -38,10 +61,27 @@ func (w *Kafka) Write(ctx context.Context, event *Event, metadata Metadata) erro
 	})
 }
 
+// Backlog returns the number and the size in bytes of the events buffered
+// while the brokers are unreachable.
+func (w *Kafka) Backlog() (messages int, bytes int64) {
+	if buffered, ok := w.writer.(*kafka.BufferedWriter); ok {
+		return buffered.Backlog()
+	}
+	return 0, 0
+}
+
 func (w *Kafka) Flush() error {
 	return nil
 }
 
 func (w *Kafka) Close() error {
-	return w.writer.Close()
+	err := w.writer.Close()
+	w.closeAdmin()
+	return err
+}
+
+func (w *Kafka) closeAdmin() {
+	if w.admin != nil {
+		_ = w.admin.Close()
+	}
 }
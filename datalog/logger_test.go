@@ -16,7 +16,7 @@ func TestNewLogger(t *testing.T) {
 			"instance_id": "JeffreyBool",
 		},
 		loggerOpts: loggerOpts{
-			path:          "../data",
+			path:          t.TempDir(),
 			encoderConfig: defaultOption.loggerOpts.encoderConfig,
 		},
 	}
@@ -32,7 +32,7 @@ func TestLogger_Write(t *testing.T) {
 			"app_id": "JeffreyBool",
 		},
 		loggerOpts: loggerOpts{
-			path:          defaultOption.loggerOpts.path,
+			path:          t.TempDir(),
 			encoderConfig: defaultOption.loggerOpts.encoderConfig,
 		},
 	}
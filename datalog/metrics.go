@@ -0,0 +1,86 @@
+package datalog
+
+import (
+	"sync"
+	"time"
+
+	"github.com/LabKiko/kiko-gokit/metrics"
+	prom "github.com/LabKiko/kiko-gokit/metrics/prometheus"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const metricsNamespace = "datalog"
+
+// Metrics instruments the exporters, any nil field is skipped. The metrics
+// are labelled by exporter name.
+type Metrics struct {
+	// Written counts the events written by an exporter.
+	Written metrics.Counter
+	// Failed counts the events an exporter failed to write.
+	Failed metrics.Counter
+	// WriteDuration observes the seconds spent in the writes of an exporter.
+	WriteDuration metrics.Observer
+}
+
+var (
+	defaultMetrics     *Metrics
+	defaultMetricsOnce sync.Once
+)
+
+// PrometheusMetrics returns the Metrics registered on the default Prometheus
+// registry, it is safe to share between several Dial.
+func PrometheusMetrics() *Metrics {
+	defaultMetricsOnce.Do(func() {
+		defaultMetrics = NewPrometheusMetrics(prometheus.DefaultRegisterer)
+	})
+	return defaultMetrics
+}
+
+// NewPrometheusMetrics creates the Metrics and registers them on registerer.
+func NewPrometheusMetrics(registerer prometheus.Registerer) *Metrics {
+	exporter := []string{"exporter"}
+	written := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Subsystem: "exporter",
+		Name:      "events_written_total",
+		Help:      "Number of events written by the exporter.",
+	}, exporter)
+	failed := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Subsystem: "exporter",
+		Name:      "events_failed_total",
+		Help:      "Number of events the exporter failed to write.",
+	}, exporter)
+	writeDuration := prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Subsystem: "exporter",
+		Name:      "write_duration_seconds",
+		Help:      "Seconds spent in the writes of the exporter.",
+		Buckets:   prometheus.DefBuckets,
+	}, exporter)
+
+	registerer.MustRegister(written, failed, writeDuration)
+
+	return &Metrics{
+		Written:       prom.NewCounter(written),
+		Failed:        prom.NewCounter(failed),
+		WriteDuration: prom.NewHistogram(writeDuration),
+	}
+}
+
+// observeWrite records the write of events by an exporter.
+func (m *Metrics) observeWrite(exporter string, events int, duration time.Duration, err error) {
+	if m == nil {
+		return
+	}
+	if err != nil {
+		if m.Failed != nil {
+			m.Failed.With(exporter).Add(float64(events))
+		}
+	} else if m.Written != nil {
+		m.Written.With(exporter).Add(float64(events))
+	}
+	if m.WriteDuration != nil {
+		m.WriteDuration.With(exporter).Observe(duration.Seconds())
+	}
+}
@@ -1,6 +1,7 @@
 package datalog
 
 import (
+	"github.com/LabKiko/kiko-gokit/kafka"
 	"go.uber.org/zap/zapcore"
 )
 
@@ -44,6 +45,14 @@ type config struct {
 	timeout  int               // 超时时间, 单位毫秒
 	metadata map[string]string // 全局元数据
 
+	schemas    *SchemaRegistry // 事件 schema, 为空时不校验
+	validation ValidationMode  // 校验失败的处理方式
+	async      *AsyncConfig    // 异步导出配置, 为空时同步导出
+
+	exporters []registeredExporter // 自定义导出器
+	disabled  map[string]bool      // 禁用的导出器名称
+	metrics   *Metrics             // 导出器指标, 为空时不统计
+
 	saOpts     // 神策打点配置
 	kafkaOpts  // kafka 配置
 	loggerOpts // 日志配置
@@ -62,7 +71,10 @@ type loggerOpts struct {
 }
 
 type kafkaOpts struct {
-	brokers []string
+	brokers        []string
+	bufferDir      string           // 本地缓冲目录, 为空时不缓冲
+	bufferMaxBytes int64            // 本地缓冲最大字节数
+	topicSpec      *kafka.TopicSpec // 自动创建 topic 的配置, 为空时不创建
 }
 
 type saOpts struct {
@@ -93,6 +105,50 @@ func WithMetadata(md map[string]string) Option {
 	})
 }
 
+// WithSchemas validates the events against the schemas of registry before
+// they are exported, mode tells how the violations are handled.
+func WithSchemas(registry *SchemaRegistry, mode ValidationMode) Option {
+	return OptionFunc(func(o *config) {
+		o.schemas = registry
+		o.validation = mode
+	})
+}
+
+// WithAsync exports the events asynchronously, Write only queues them for
+// each exporter. Flush and Close wait for the queued events to be written.
+func WithAsync(async AsyncConfig) Option {
+	return OptionFunc(func(o *config) {
+		o.async = &async
+	})
+}
+
+// WithExporter adds exporter to the built-in ones, the events are filtered
+// by opts first when set. Its name is the one of a NamedExporter.
+func WithExporter(exporter Exporter, opts ...FilterOption) Option {
+	return OptionFunc(func(o *config) {
+		o.exporters = append(o.exporters, registeredExporter{name: exporterName(exporter), exporter: exporter, filters: opts})
+	})
+}
+
+// WithExporterDisable disables the exporter named name, built-in or added
+// by WithExporter.
+func WithExporterDisable(name string, disable bool) Option {
+	return OptionFunc(func(o *config) {
+		if o.disabled == nil {
+			o.disabled = make(map[string]bool)
+		}
+		o.disabled[name] = disable
+	})
+}
+
+// WithMetrics records the writes of each exporter, e.g.
+// WithMetrics(PrometheusMetrics()).
+func WithMetrics(metrics *Metrics) Option {
+	return OptionFunc(func(o *config) {
+		o.metrics = metrics
+	})
+}
+
 // WithLogDisable disable logger
 func WithLogDisable(disable bool) Option {
 	return OptionFunc(func(o *config) {
@@ -106,6 +162,24 @@ func WithBrokers(brokers []string) Option {
 	})
 }
 
+// WithKafkaBuffer buffers the events in dir when the brokers are
+// unreachable and replays them once they are back, maxBytes bounds the disk
+// usage, zero keeps the default of kafka.BufferMaxBytes.
+func WithKafkaBuffer(dir string, maxBytes int64) Option {
+	return OptionFunc(func(o *config) {
+		o.kafkaOpts.bufferDir = dir
+		o.kafkaOpts.bufferMaxBytes = maxBytes
+	})
+}
+
+// WithKafkaTopics creates the missing event topics on their first write
+// from spec, whose name is ignored.
+func WithKafkaTopics(spec kafka.TopicSpec) Option {
+	return OptionFunc(func(o *config) {
+		o.kafkaOpts.topicSpec = &spec
+	})
+}
+
 // WithBasePath set base path.
 func WithBasePath(path string) Option {
 	return OptionFunc(func(o *config) {
@@ -0,0 +1,209 @@
+package datalog
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/LabKiko/kiko-gokit/datalog/attribute"
+	"github.com/LabKiko/kiko-gokit/logger"
+	"gopkg.in/yaml.v3"
+)
+
+// ValidationMode 事件校验失败时的处理方式
+type ValidationMode int8
+
+const (
+	// ValidationReject rejects the events violating their schema, Write
+	// returns a *ValidationError and no exporter is called.
+	ValidationReject ValidationMode = iota
+	// ValidationWarn logs the violations and writes the events as is.
+	ValidationWarn
+	// ValidationStrip drops the unknown properties with a warning, the other
+	// violations are rejected.
+	ValidationStrip
+)
+
+// PropertySchema describes a property of an event.
+type PropertySchema struct {
+	Type     attribute.Type
+	Required bool
+}
+
+// EventSchema describes the properties of an event, the properties not
+// declared are unknown.
+type EventSchema struct {
+	Name       string
+	Properties map[string]PropertySchema
+}
+
+// ValidationError lists the violations of the schema of an event.
+type ValidationError struct {
+	Event      string
+	Violations []string
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("datalog: event %s: %s", e.Event, strings.Join(e.Violations, "; "))
+}
+
+// SchemaRegistry holds the schemas of the events, it is safe for concurrent use.
+type SchemaRegistry struct {
+	mutex  sync.RWMutex
+	events map[string]*EventSchema
+}
+
+// NewSchemaRegistry creates a registry holding schemas.
+func NewSchemaRegistry(schemas ...*EventSchema) *SchemaRegistry {
+	r := &SchemaRegistry{events: make(map[string]*EventSchema)}
+	for _, schema := range schemas {
+		r.Register(schema)
+	}
+	return r
+}
+
+// Register adds schema, replacing the one of the same event.
+func (r *SchemaRegistry) Register(schema *EventSchema) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.events[schema.Name] = schema
+}
+
+// Lookup returns the schema of the event name.
+func (r *SchemaRegistry) Lookup(name string) (*EventSchema, bool) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	schema, ok := r.events[name]
+	return schema, ok
+}
+
+// schemaFile is the YAML or JSON document loaded by Load, e.g.:
+//
+//	events:
+//	  adddepartmentsuccess:
+//	    properties:
+//	      team_id: {type: string, required: true}
+//	      members: {type: int64}
+//
+// The types are the names of the attribute.Type, case insensitive.
+type schemaFile struct {
+	Events map[string]struct {
+		Properties map[string]struct {
+			Type     string `yaml:"type"`
+			Required bool   `yaml:"required"`
+		} `yaml:"properties"`
+	} `yaml:"events"`
+}
+
+// Load registers the schemas of a YAML or JSON document.
+func (r *SchemaRegistry) Load(data []byte) error {
+	var file schemaFile
+	// JSON is a subset of YAML.
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return fmt.Errorf("datalog: parse schemas: %w", err)
+	}
+
+	schemas := make([]*EventSchema, 0, len(file.Events))
+	for name, event := range file.Events {
+		schema := &EventSchema{Name: name, Properties: make(map[string]PropertySchema, len(event.Properties))}
+		for key, property := range event.Properties {
+			typ, err := parseType(property.Type)
+			if err != nil {
+				return fmt.Errorf("datalog: event %s property %s: %w", name, key, err)
+			}
+			schema.Properties[key] = PropertySchema{Type: typ, Required: property.Required}
+		}
+		schemas = append(schemas, schema)
+	}
+	for _, schema := range schemas {
+		r.Register(schema)
+	}
+	return nil
+}
+
+// LoadFile registers the schemas of a YAML or JSON file.
+func (r *SchemaRegistry) LoadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	return r.Load(data)
+}
+
+func parseType(name string) (attribute.Type, error) {
+	for typ := attribute.BOOL; typ <= attribute.STRINGSLICE; typ++ {
+		if strings.EqualFold(typ.String(), name) {
+			return typ, nil
+		}
+	}
+	return attribute.INVALID, fmt.Errorf("unknown type %q", name)
+}
+
+// Validate checks attributes against the schema of event, it returns a
+// *ValidationError listing the violations.
+func (r *SchemaRegistry) Validate(event *Event, attributes []attribute.KeyValue) error {
+	violations, unknown := r.check(event, attributes)
+	if len(violations)+len(unknown) == 0 {
+		return nil
+	}
+	return &ValidationError{Event: event.Name, Violations: append(violations, unknown...)}
+}
+
+// apply validates attributes according to mode and returns the ones to write.
+func (r *SchemaRegistry) apply(mode ValidationMode, event *Event, attributes []attribute.KeyValue) ([]attribute.KeyValue, error) {
+	violations, unknown := r.check(event, attributes)
+	switch {
+	case len(violations)+len(unknown) == 0:
+		return attributes, nil
+	case mode == ValidationWarn:
+		logger.Warn(&ValidationError{Event: event.Name, Violations: append(violations, unknown...)})
+		return attributes, nil
+	case mode == ValidationStrip && len(violations) == 0:
+		logger.Warn(&ValidationError{Event: event.Name, Violations: unknown})
+		schema, _ := r.Lookup(event.Name)
+		stripped := make([]attribute.KeyValue, 0, len(attributes))
+		for _, kv := range attributes {
+			if _, ok := schema.Properties[string(kv.Key)]; ok {
+				stripped = append(stripped, kv)
+			}
+		}
+		return stripped, nil
+	default:
+		return nil, &ValidationError{Event: event.Name, Violations: append(violations, unknown...)}
+	}
+}
+
+// check returns the violations of the schema of event, the unknown
+// properties apart.
+func (r *SchemaRegistry) check(event *Event, attributes []attribute.KeyValue) (violations, unknown []string) {
+	schema, ok := r.Lookup(event.Name)
+	if !ok {
+		return []string{"unknown event"}, nil
+	}
+
+	present := make(map[string]struct{}, len(attributes))
+	for _, kv := range attributes {
+		key := string(kv.Key)
+		present[key] = struct{}{}
+		property, ok := schema.Properties[key]
+		if !ok {
+			unknown = append(unknown, fmt.Sprintf("unknown property %s", key))
+			continue
+		}
+		if kv.Value.Type() != property.Type {
+			violations = append(violations, fmt.Sprintf("property %s is %s, not %s", key, kv.Value.Type(), property.Type))
+		}
+	}
+	for key, property := range schema.Properties {
+		if _, ok := present[key]; property.Required && !ok {
+			violations = append(violations, fmt.Sprintf("missing property %s", key))
+		}
+	}
+	// The properties are iterated in random order.
+	sort.Strings(violations)
+	return violations, unknown
+}
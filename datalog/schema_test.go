@@ -0,0 +1,84 @@
+package datalog
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/LabKiko/kiko-gokit/datalog/attribute"
+	"github.com/stretchr/testify/assert"
+)
+
+// recordExporter records the events and metadata written.
+type recordExporter struct {
+	mutex    sync.Mutex
+	events   []Event
+	metadata []Metadata
+}
+
+func (e *recordExporter) Write(ctx context.Context, event *Event, metadata Metadata) error {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.events = append(e.events, *event)
+	e.metadata = append(e.metadata, metadata)
+	return nil
+}
+
+func (e *recordExporter) Flush() error { return nil }
+
+func (e *recordExporter) Close() error { return nil }
+
+func TestSchemaRegistry_Load(t *testing.T) {
+	registry := NewSchemaRegistry()
+	assert.Nil(t, registry.Load([]byte(`
+events:
+  adddepartmentsuccess:
+    properties:
+      team_id: {type: string, required: true}
+      members: {type: INT64}
+`)))
+	assert.Nil(t, registry.Load([]byte(`{"events": {"login": {"properties": {"tags": {"type": "stringslice"}}}}}`)))
+
+	schema, ok := registry.Lookup("adddepartmentsuccess")
+	assert.True(t, ok)
+	assert.Equal(t, PropertySchema{Type: attribute.STRING, Required: true}, schema.Properties["team_id"])
+	assert.Equal(t, attribute.INT64, schema.Properties["members"].Type)
+	schema, _ = registry.Lookup("login")
+	assert.Equal(t, attribute.STRINGSLICE, schema.Properties["tags"].Type)
+
+	assert.NotNil(t, registry.Load([]byte(`events: {login: {properties: {tags: {type: map}}}}`)))
+}
+
+func TestSchemaRegistry_Validate(t *testing.T) {
+	registry := NewSchemaRegistry(&EventSchema{Name: "login", Properties: map[string]PropertySchema{
+		"team_id": {Type: attribute.STRING, Required: true},
+		"members": {Type: attribute.INT64},
+	}})
+	event := &Event{Name: "login", DistinctId: "1"}
+
+	assert.Nil(t, registry.Validate(event, []attribute.KeyValue{attribute.String("team_id", "1")}))
+	err := registry.Validate(event, []attribute.KeyValue{attribute.String("members", "3"), attribute.String("team", "1")})
+	var validationErr *ValidationError
+	assert.True(t, errors.As(err, &validationErr))
+	assert.Equal(t, []string{"missing property team_id", "property members is STRING, not INT64", "unknown property team"}, validationErr.Violations)
+	assert.NotNil(t, registry.Validate(&Event{Name: "logni"}, nil))
+
+	exporter := &recordExporter{}
+	provider := func(mode ValidationMode) *datalogProvider {
+		return &datalogProvider{opts: &config{appId: "app", schemas: registry, validation: mode}, exporters: []Exporter{exporter}}
+	}
+	unknown := []attribute.KeyValue{attribute.String("team_id", "1"), attribute.String("team", "1")}
+
+	assert.NotNil(t, provider(ValidationReject).Write(context.Background(), event, unknown...))
+	assert.Len(t, exporter.metadata, 0)
+
+	assert.Nil(t, provider(ValidationWarn).Write(context.Background(), event, unknown...))
+	assert.Equal(t, "1", exporter.metadata[0]["team"])
+
+	assert.Nil(t, provider(ValidationStrip).Write(context.Background(), event, unknown...))
+	assert.NotContains(t, exporter.metadata[1], "team")
+	assert.Equal(t, "1", exporter.metadata[1]["team_id"])
+	// Only the unknown properties are stripped.
+	assert.NotNil(t, provider(ValidationStrip).Write(context.Background(), event, attribute.Int64("team_id", 1)))
+}
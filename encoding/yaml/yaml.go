@@ -2,6 +2,7 @@ package yaml
 
 import (
 	"github.com/LabKiko/kiko-gokit/encoding"
+	"gopkg.in/yaml.v3"
 )
 
 // Name is the name registered for the yaml codec.
@@ -0,0 +1,371 @@
+package kafka
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/LabKiko/kiko-gokit/logger"
+	"github.com/Shopify/sarama"
+	"go.uber.org/multierr"
+)
+
+// Admin inspects the consumer groups of a cluster, manages their offsets and
+// the topics.
+type Admin interface {
+	// ListGroups returns the ids of the consumer groups, sorted.
+	ListGroups() ([]string, error)
+	// DescribeGroup returns the state, the members and the partition
+	// assignments of a consumer group.
+	DescribeGroup(group string) (*GroupDescription, error)
+	// Lag returns the lag of the consumer group on every partition of topic.
+	Lag(group, topic string) ([]*PartitionLag, error)
+	// ResetOffsets moves the committed offsets of the consumer group on every
+	// partition of topic to OffsetOldest or OffsetNewest. The group must not
+	// have active members.
+	ResetOffsets(group, topic string, offset StartOffset) error
+	// ResetOffsetsToTime moves the committed offsets of the consumer group on
+	// every partition of topic to the first message produced at or after t.
+	// The group must not have active members.
+	ResetOffsetsToTime(group, topic string, t time.Time) error
+	// DiffTopics compares the specs with the actual topics and returns the
+	// differences, the topics matching their spec are omitted.
+	DiffTopics(specs ...TopicSpec) ([]*TopicDiff, error)
+	// EnsureTopics creates the missing topics, adds partitions and alters
+	// the configs of the existing ones to match the specs.
+	EnsureTopics(specs ...TopicSpec) error
+	Close() error
+}
+
+// ErrGroupActive is returned when resetting the offsets of a group with members.
+var ErrGroupActive = errors.New("kafka: consumer group has active members")
+
+// GroupDescription describes a consumer group.
+type GroupDescription struct {
+	GroupID  string
+	State    string
+	Protocol string
+	Members  []*GroupMember
+}
+
+// GroupMember is a member of a consumer group and its assigned partitions.
+type GroupMember struct {
+	MemberID    string
+	ClientID    string
+	ClientHost  string
+	Assignments map[string][]int32
+}
+
+// PartitionLag is the lag of a consumer group on a partition. Committed is -1
+// when the group never committed an offset on the partition, the lag is then
+// computed from the oldest available offset.
+type PartitionLag struct {
+	Topic         string
+	Partition     int32
+	Committed     int64
+	HighWaterMark int64
+	Lag           int64
+}
+
+type AdminOpts struct {
+	ClientOpts
+
+	ServiceName string
+	Brokers     []string
+
+	Logger logger.Logger
+}
+
+type AdminOpt func(o *AdminOpts)
+
+func newAdminOptions(brokers []string, opts ...AdminOpt) *AdminOpts {
+	opt := &AdminOpts{
+		ServiceName: defaultAdminServiceName,
+		Brokers:     brokers,
+	}
+	for _, o := range opts {
+		o(opt)
+	}
+	if opt.Logger == nil {
+		opt.Logger = logger.New(
+			logger.WithNamespace("kafka"),
+			logger.WithConsole(true),
+			logger.WithDisableDisk(true),
+			logger.WithFields(map[string]interface{}{
+				"app_id": opt.ServiceName,
+				"event":  "admin",
+				"broker": strings.Join(brokers, ","),
+			}),
+		)
+	}
+	return opt
+}
+
+func AdminServiceName(serviceName string) AdminOpt {
+	return func(o *AdminOpts) {
+		o.ServiceName = serviceName
+	}
+}
+
+// AdminVersion sets the version of the brokers, e.g. "2.8.1".
+func AdminVersion(version string) AdminOpt {
+	return func(o *AdminOpts) {
+		o.Version = version
+	}
+}
+
+// AdminTLS enables TLS towards the brokers.
+func AdminTLS(config *TLSConfig) AdminOpt {
+	return func(o *AdminOpts) {
+		o.TLS = config
+	}
+}
+
+// AdminSASL enables SASL authentication towards the brokers.
+func AdminSASL(config *SASLConfig) AdminOpt {
+	return func(o *AdminOpts) {
+		o.SASL = config
+	}
+}
+
+// AdminDebug enables the sarama debug logs while the admin is open.
+func AdminDebug(debug bool) AdminOpt {
+	return func(o *AdminOpts) {
+		o.Debug = debug
+	}
+}
+
+func AdminLogger(logger logger.Logger) AdminOpt {
+	return func(o *AdminOpts) {
+		o.Logger = logger
+	}
+}
+
+// AdminSaramaConfig mutates the generated sarama config before the client is created.
+func AdminSaramaConfig(fn func(config *sarama.Config)) AdminOpt {
+	return func(o *AdminOpts) {
+		o.ConfigFunc = fn
+	}
+}
+
+type admin struct {
+	opts   *AdminOpts
+	client sarama.Client
+	admin  sarama.ClusterAdmin
+
+	// unregisterLogs disables the sarama debug logs enabled by the admin.
+	unregisterLogs func()
+}
+
+// NewAdmin connects to the cluster.
+func NewAdmin(brokers []string, opts ...AdminOpt) (Admin, error) {
+	options := newAdminOptions(brokers, opts...)
+	config, err := newClientConfig(options.ServiceName, options.ClientOpts)
+	if err != nil {
+		return nil, err
+	}
+	// Report the commit errors of ResetOffsets instead of logging them.
+	config.Consumer.Return.Errors = true
+	if options.ConfigFunc != nil {
+		options.ConfigFunc(config)
+	}
+
+	unregisterLogs := options.registerLogs()
+	client, err := sarama.NewClient(options.Brokers, config)
+	if err != nil {
+		unregisterLogs()
+		return nil, err
+	}
+	clusterAdmin, err := sarama.NewClusterAdminFromClient(client)
+	if err != nil {
+		_ = client.Close()
+		unregisterLogs()
+		return nil, err
+	}
+
+	return &admin{opts: options, client: client, admin: clusterAdmin, unregisterLogs: unregisterLogs}, nil
+}
+
+func (a *admin) ListGroups() ([]string, error) {
+	groups, err := a.admin.ListConsumerGroups()
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]string, 0, len(groups))
+	for id := range groups {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
+
+func (a *admin) DescribeGroup(group string) (*GroupDescription, error) {
+	descriptions, err := a.admin.DescribeConsumerGroups([]string{group})
+	if err != nil {
+		return nil, err
+	}
+	if len(descriptions) == 0 {
+		return nil, fmt.Errorf("kafka: consumer group %s not found", group)
+	}
+	d := descriptions[0]
+	if d.Err != sarama.ErrNoError {
+		return nil, d.Err
+	}
+
+	description := &GroupDescription{
+		GroupID:  d.GroupId,
+		State:    d.State,
+		Protocol: d.Protocol,
+		Members:  make([]*GroupMember, 0, len(d.Members)),
+	}
+	for id, m := range d.Members {
+		member := &GroupMember{
+			MemberID:    id,
+			ClientID:    m.ClientId,
+			ClientHost:  m.ClientHost,
+			Assignments: make(map[string][]int32),
+		}
+		assignment, err := m.GetMemberAssignment()
+		if err != nil {
+			return nil, err
+		}
+		if assignment != nil {
+			for topic, partitions := range assignment.Topics {
+				member.Assignments[topic] = partitions
+			}
+		}
+		description.Members = append(description.Members, member)
+	}
+	sort.Slice(description.Members, func(i, j int) bool {
+		return description.Members[i].MemberID < description.Members[j].MemberID
+	})
+
+	return description, nil
+}
+
+func (a *admin) Lag(group, topic string) ([]*PartitionLag, error) {
+	partitions, err := a.client.Partitions(topic)
+	if err != nil {
+		return nil, err
+	}
+
+	committed, err := a.admin.ListConsumerGroupOffsets(group, map[string][]int32{topic: partitions})
+	if err != nil {
+		return nil, err
+	}
+
+	lags := make([]*PartitionLag, 0, len(partitions))
+	for _, partition := range partitions {
+		highWaterMark, err := a.client.GetOffset(topic, partition, sarama.OffsetNewest)
+		if err != nil {
+			return nil, err
+		}
+
+		lag := &PartitionLag{
+			Topic:         topic,
+			Partition:     partition,
+			Committed:     -1,
+			HighWaterMark: highWaterMark,
+		}
+		if block := committed.GetBlock(topic, partition); block != nil {
+			if block.Err != sarama.ErrNoError {
+				return nil, block.Err
+			}
+			lag.Committed = block.Offset
+		}
+
+		from := lag.Committed
+		if from < 0 {
+			if from, err = a.client.GetOffset(topic, partition, sarama.OffsetOldest); err != nil {
+				return nil, err
+			}
+		}
+		if lag.Lag = highWaterMark - from; lag.Lag < 0 {
+			lag.Lag = 0
+		}
+		lags = append(lags, lag)
+	}
+
+	return lags, nil
+}
+
+func (a *admin) ResetOffsets(group, topic string, offset StartOffset) error {
+	if offset != OffsetOldest && offset != OffsetNewest {
+		return fmt.Errorf("kafka: invalid reset offset: %d", offset)
+	}
+	return a.resetOffsets(group, topic, int64(offset))
+}
+
+func (a *admin) ResetOffsetsToTime(group, topic string, t time.Time) error {
+	return a.resetOffsets(group, topic, t.UnixNano()/int64(time.Millisecond))
+}
+
+// resetOffsets commits, for every partition of topic, the offset returned by
+// the brokers for at, which is either a timestamp in milliseconds or one of
+// sarama.OffsetOldest and sarama.OffsetNewest.
+func (a *admin) resetOffsets(group, topic string, at int64) error {
+	description, err := a.DescribeGroup(group)
+	if err != nil {
+		return err
+	}
+	if len(description.Members) > 0 {
+		return ErrGroupActive
+	}
+
+	partitions, err := a.client.Partitions(topic)
+	if err != nil {
+		return err
+	}
+
+	offsetManager, err := sarama.NewOffsetManagerFromClient(group, a.client)
+	if err != nil {
+		return err
+	}
+	defer offsetManager.Close()
+
+	poms := make([]sarama.PartitionOffsetManager, 0, len(partitions))
+	for _, partition := range partitions {
+		offset, err := a.client.GetOffset(topic, partition, at)
+		if err != nil {
+			return err
+		}
+		// No message was produced after the timestamp.
+		if offset < 0 {
+			if offset, err = a.client.GetOffset(topic, partition, sarama.OffsetNewest); err != nil {
+				return err
+			}
+		}
+
+		pom, err := offsetManager.ManagePartition(topic, partition)
+		if err != nil {
+			return err
+		}
+		// ResetOffset only moves backwards and MarkOffset only forwards.
+		pom.ResetOffset(offset, "")
+		pom.MarkOffset(offset, "")
+		pom.AsyncClose()
+		poms = append(poms, pom)
+	}
+
+	// Closing the offset manager flushes the offsets and releases the
+	// partition managers, which closes their error channels.
+	offsetManager.Commit()
+	_ = offsetManager.Close()
+	for _, pom := range poms {
+		for e := range pom.Errors() {
+			err = multierr.Append(err, e)
+		}
+	}
+	return err
+}
+
+func (a *admin) Close() error {
+	err := a.admin.Close()
+	if a.unregisterLogs != nil {
+		a.unregisterLogs()
+	}
+	return err
+}
@@ -0,0 +1,92 @@
+package kafka
+
+import (
+	"testing"
+
+	"github.com/Shopify/sarama"
+	"github.com/stretchr/testify/assert"
+)
+
+func newMockAdmin(t *testing.T) (Admin, *sarama.MockBroker) {
+	broker := sarama.NewMockBroker(t, 1)
+	broker.SetHandlerByMap(map[string]sarama.MockResponse{
+		"MetadataRequest": sarama.NewMockMetadataResponse(t).
+			SetBroker(broker.Addr(), broker.BrokerID()).
+			SetController(broker.BrokerID()).
+			SetLeader("orders", 0, broker.BrokerID()).
+			SetLeader("orders", 1, broker.BrokerID()),
+		"FindCoordinatorRequest": sarama.NewMockFindCoordinatorResponse(t).
+			SetCoordinator(sarama.CoordinatorGroup, "billing", broker),
+		"ListGroupsRequest": sarama.NewMockListGroupsResponse(t).
+			AddGroup("billing", "consumer").
+			AddGroup("audit", "consumer"),
+		"DescribeGroupsRequest": sarama.NewMockDescribeGroupsResponse(t).
+			AddGroupDescription("billing", &sarama.GroupDescription{
+				GroupId:  "billing",
+				State:    "Empty",
+				Protocol: "roundrobin",
+			}),
+		"OffsetFetchRequest": sarama.NewMockOffsetFetchResponse(t).
+			SetOffset("billing", "orders", 0, 7, "", sarama.ErrNoError).
+			SetOffset("billing", "orders", 1, -1, "", sarama.ErrNoError),
+		"OffsetRequest": sarama.NewMockOffsetResponse(t).
+			SetOffset("orders", 0, sarama.OffsetNewest, 10).
+			SetOffset("orders", 0, sarama.OffsetOldest, 0).
+			SetOffset("orders", 1, sarama.OffsetNewest, 4).
+			SetOffset("orders", 1, sarama.OffsetOldest, 1),
+		"OffsetCommitRequest": sarama.NewMockOffsetCommitResponse(t),
+	})
+
+	a, err := NewAdmin([]string{broker.Addr()}, AdminVersion("2.1.0"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return a, broker
+}
+
+func TestAdmin_Groups(t *testing.T) {
+	a, broker := newMockAdmin(t)
+	defer broker.Close()
+	defer a.Close()
+
+	groups, err := a.ListGroups()
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"audit", "billing"}, groups)
+
+	description, err := a.DescribeGroup("billing")
+	assert.Nil(t, err)
+	assert.Equal(t, "Empty", description.State)
+	assert.Empty(t, description.Members)
+}
+
+func TestAdmin_Lag(t *testing.T) {
+	a, broker := newMockAdmin(t)
+	defer broker.Close()
+	defer a.Close()
+
+	lags, err := a.Lag("billing", "orders")
+	assert.Nil(t, err)
+	assert.Len(t, lags, 2)
+	assert.Equal(t, &PartitionLag{Topic: "orders", Partition: 0, Committed: 7, HighWaterMark: 10, Lag: 3}, lags[0])
+	assert.Equal(t, &PartitionLag{Topic: "orders", Partition: 1, Committed: -1, HighWaterMark: 4, Lag: 3}, lags[1])
+}
+
+func TestAdmin_ResetOffsets(t *testing.T) {
+	a, broker := newMockAdmin(t)
+	defer broker.Close()
+	defer a.Close()
+
+	assert.Nil(t, a.ResetOffsets("billing", "orders", OffsetOldest))
+	committed := make(map[int32]int64)
+	for _, rr := range broker.History() {
+		if req, ok := rr.Request.(*sarama.OffsetCommitRequest); ok {
+			for _, partition := range []int32{0, 1} {
+				if offset, _, err := req.Offset("orders", partition); err == nil {
+					committed[partition] = offset
+				}
+			}
+		}
+	}
+	assert.Equal(t, map[int32]int64{0: 0, 1: 1}, committed)
+	assert.NotNil(t, a.ResetOffsets("billing", "orders", StartOffset(3)))
+}
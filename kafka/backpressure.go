@@ -0,0 +1,294 @@
+package kafka
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/LabKiko/kiko-gokit/logger"
+)
+
+const (
+	defaultBackpressureWindow   = 20
+	defaultBackpressureCooldown = 5 * time.Second
+)
+
+// Backpressure pauses the assigned partitions of a reader, without leaving
+// the group, while its handlers are saturated or failing. The messages
+// already fetched are still handled.
+type Backpressure struct {
+	// MaxInFlight is the number of handlers running at the same time above
+	// which the partitions are paused, the next handlers wait for a running
+	// one to return. Zero disables the limit.
+	MaxInFlight int
+
+	// ErrorRate is the ratio of failed handlers over the last Window
+	// results, between 0 and 1, at which the partitions are paused for
+	// Cooldown. Zero disables the threshold.
+	ErrorRate float64
+
+	// Window is the number of handler results the error rate is computed
+	// on, default 20.
+	Window int
+
+	// Cooldown is how long the partitions stay paused once the error rate
+	// is reached before consuming is tried again, default 5s.
+	Cooldown time.Duration
+}
+
+// partitionPauser stops and restarts fetching from partitions, it is
+// implemented by sarama.ConsumerGroup.
+type partitionPauser interface {
+	Pause(partitions map[string][]int32)
+	Resume(partitions map[string][]int32)
+}
+
+// flowControl tracks the partitions paused with Reader.Pause and by
+// backpressure and applies their state to the assigned partitions.
+type flowControl struct {
+	config Backpressure
+	logger logger.Logger
+
+	mutex    sync.Mutex
+	cond     *sync.Cond
+	pauser   partitionPauser
+	assigned map[string][]int32
+	manual   map[string]map[int32]bool
+
+	// backpressure state
+	inFlight  int
+	saturated bool
+	failing   bool
+	results   []bool
+	next      int
+	cooldown  *time.Timer
+	stopped   bool
+}
+
+func newFlowControl(config *Backpressure, log logger.Logger) *flowControl {
+	f := &flowControl{
+		logger:   log,
+		assigned: make(map[string][]int32),
+		manual:   make(map[string]map[int32]bool),
+	}
+	if config != nil {
+		f.config = *config
+	}
+	if f.config.Window <= 0 {
+		f.config.Window = defaultBackpressureWindow
+	}
+	if f.config.Cooldown <= 0 {
+		f.config.Cooldown = defaultBackpressureCooldown
+	}
+	f.cond = sync.NewCond(&f.mutex)
+	return f
+}
+
+// setPauser sets the consumer the paused partitions are applied to.
+func (f *flowControl) setPauser(pauser partitionPauser) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	f.pauser = pauser
+}
+
+// assign records the partitions of a new session.
+func (f *flowControl) assign(claims map[string][]int32) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	f.assigned = make(map[string][]int32, len(claims))
+	for topic, partitions := range claims {
+		f.assigned[topic] = append([]int32(nil), partitions...)
+	}
+}
+
+// claim pauses the partition, once its consumer started, if it is paused.
+func (f *flowControl) claim(topic string, partition int32) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if f.pauser != nil && f.isPaused(topic, partition) {
+		f.pauser.Pause(map[string][]int32{topic: {partition}})
+	}
+}
+
+// pause pauses partitions of topic, the assigned ones when none is given.
+func (f *flowControl) pause(topic string, partitions []int32) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if len(partitions) == 0 {
+		partitions = f.assigned[topic]
+	}
+	if f.manual[topic] == nil {
+		f.manual[topic] = make(map[int32]bool)
+	}
+	changed := make([]int32, 0, len(partitions))
+	for _, p := range partitions {
+		if !f.isPaused(topic, p) && f.isAssigned(topic, p) {
+			changed = append(changed, p)
+		}
+		f.manual[topic][p] = true
+	}
+	if f.pauser != nil && len(changed) > 0 {
+		f.pauser.Pause(map[string][]int32{topic: changed})
+	}
+}
+
+// resume resumes partitions of topic, all of them when none is given.
+func (f *flowControl) resume(topic string, partitions []int32) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	manual := f.manual[topic]
+	if len(partitions) == 0 {
+		partitions = make([]int32, 0, len(manual))
+		for p := range manual {
+			partitions = append(partitions, p)
+		}
+		sort.Slice(partitions, func(i, j int) bool { return partitions[i] < partitions[j] })
+	}
+	changed := make([]int32, 0, len(partitions))
+	for _, p := range partitions {
+		if !manual[p] {
+			continue
+		}
+		delete(manual, p)
+		if !f.backpressured() && f.isAssigned(topic, p) {
+			changed = append(changed, p)
+		}
+	}
+	if len(manual) == 0 {
+		delete(f.manual, topic)
+	}
+	if f.pauser != nil && len(changed) > 0 {
+		f.pauser.Resume(map[string][]int32{topic: changed})
+	}
+}
+
+// begin is called before a handler runs, it waits while MaxInFlight
+// handlers are running.
+func (f *flowControl) begin() {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	for f.config.MaxInFlight > 0 && f.inFlight >= f.config.MaxInFlight && !f.stopped {
+		f.cond.Wait()
+	}
+	f.backpressureLocked(func() {
+		f.inFlight++
+		if f.config.MaxInFlight > 0 && f.inFlight >= f.config.MaxInFlight {
+			f.saturated = true
+		}
+	})
+}
+
+// end is called with the result of a handler once it returned.
+func (f *flowControl) end(err error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	f.backpressureLocked(func() {
+		f.inFlight--
+		if f.inFlight < f.config.MaxInFlight {
+			f.saturated = false
+		}
+		f.cond.Broadcast()
+
+		if f.config.ErrorRate <= 0 || f.failing {
+			return
+		}
+		if len(f.results) < f.config.Window {
+			f.results = append(f.results, err != nil)
+		} else {
+			f.results[f.next] = err != nil
+			f.next = (f.next + 1) % f.config.Window
+		}
+		if len(f.results) < f.config.Window {
+			return
+		}
+		failed := 0
+		for _, r := range f.results {
+			if r {
+				failed++
+			}
+		}
+		if float64(failed)/float64(len(f.results)) >= f.config.ErrorRate {
+			f.logger.Warnf("handler error rate %d/%d reached, pausing for %s", failed, len(f.results), f.config.Cooldown)
+			f.failing = true
+			f.results, f.next = f.results[:0], 0
+			f.cooldown = time.AfterFunc(f.config.Cooldown, f.recover)
+		}
+	})
+}
+
+// recover resumes consuming once the cooldown elapsed, the error rate is
+// then computed again from scratch.
+func (f *flowControl) recover() {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	f.backpressureLocked(func() {
+		f.failing = false
+	})
+}
+
+// stop releases the waiting handlers and the cooldown timer.
+func (f *flowControl) stop() {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	f.stopped = true
+	if f.cooldown != nil {
+		f.cooldown.Stop()
+	}
+	f.cond.Broadcast()
+}
+
+// backpressureLocked applies fn to the backpressure state and, when it
+// changed, pauses or resumes the assigned partitions not paused with
+// Reader.Pause. It does not allocate while the state is unchanged, as on
+// every handler without backpressure.
+func (f *flowControl) backpressureLocked(fn func()) {
+	before := f.backpressured()
+	fn()
+	after := f.backpressured()
+	if before == after || f.pauser == nil {
+		return
+	}
+
+	partitions := make(map[string][]int32, len(f.assigned))
+	for topic, assigned := range f.assigned {
+		for _, p := range assigned {
+			if !f.manual[topic][p] {
+				partitions[topic] = append(partitions[topic], p)
+			}
+		}
+	}
+	if len(partitions) == 0 {
+		return
+	}
+	if after {
+		f.pauser.Pause(partitions)
+	} else {
+		f.pauser.Resume(partitions)
+	}
+}
+
+func (f *flowControl) backpressured() bool {
+	return f.saturated || f.failing
+}
+
+func (f *flowControl) isAssigned(topic string, partition int32) bool {
+	for _, p := range f.assigned[topic] {
+		if p == partition {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *flowControl) isPaused(topic string, partition int32) bool {
+	return f.backpressured() || f.manual[topic][partition]
+}
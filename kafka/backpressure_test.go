@@ -0,0 +1,172 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/LabKiko/kiko-gokit/logger"
+	"github.com/Shopify/sarama"
+	"github.com/stretchr/testify/assert"
+)
+
+type recordingPauser struct {
+	mutex  sync.Mutex
+	paused map[int32]bool
+}
+
+func (p *recordingPauser) Pause(partitions map[string][]int32) {
+	p.set(partitions, true)
+}
+
+func (p *recordingPauser) Resume(partitions map[string][]int32) {
+	p.set(partitions, false)
+}
+
+func (p *recordingPauser) set(partitions map[string][]int32, paused bool) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	for _, partition := range partitions["t"] {
+		p.paused[partition] = paused
+	}
+}
+
+func (p *recordingPauser) get() map[int32]bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	paused := make(map[int32]bool)
+	for partition, ok := range p.paused {
+		if ok {
+			paused[partition] = true
+		}
+	}
+	return paused
+}
+
+func newTestFlowControl(config *Backpressure) (*flowControl, *recordingPauser) {
+	pauser := &recordingPauser{paused: make(map[int32]bool)}
+	f := newFlowControl(config, logger.DefaultLogger)
+	f.setPauser(pauser)
+	f.assign(map[string][]int32{"t": {0, 1, 2}})
+	return f, pauser
+}
+
+func TestFlowControl_Manual(t *testing.T) {
+	f, pauser := newTestFlowControl(nil)
+
+	f.pause("t", []int32{1})
+	assert.Equal(t, map[int32]bool{1: true}, pauser.get())
+	f.pause("t", nil)
+	assert.Equal(t, map[int32]bool{0: true, 1: true, 2: true}, pauser.get())
+	f.resume("t", []int32{0})
+	assert.Equal(t, map[int32]bool{1: true, 2: true}, pauser.get())
+
+	// The pause survives a rebalance, the partition is paused again once
+	// its consumer started.
+	f.assign(map[string][]int32{"t": {2, 3}})
+	pauser.paused = make(map[int32]bool)
+	f.claim("t", 2)
+	f.claim("t", 3)
+	assert.Equal(t, map[int32]bool{2: true}, pauser.get())
+
+	f.resume("t", nil)
+	assert.Equal(t, map[int32]bool{}, pauser.get())
+}
+
+func TestFlowControl_MaxInFlight(t *testing.T) {
+	f, pauser := newTestFlowControl(&Backpressure{MaxInFlight: 2})
+	f.pause("t", []int32{0})
+
+	f.begin()
+	assert.Equal(t, map[int32]bool{0: true}, pauser.get())
+	f.begin()
+	assert.Equal(t, map[int32]bool{0: true, 1: true, 2: true}, pauser.get())
+
+	waiting := make(chan struct{})
+	go func() {
+		f.begin()
+		close(waiting)
+	}()
+	select {
+	case <-waiting:
+		t.Fatal("begin() did not wait for a running handler")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	f.end(nil)
+	<-waiting
+	f.end(nil)
+	f.end(nil)
+	// The manually paused partition stays paused.
+	assert.Equal(t, map[int32]bool{0: true}, pauser.get())
+}
+
+func TestFlowControl_Allocs(t *testing.T) {
+	// The handlers do not allocate while the backpressure state is unchanged.
+	for _, config := range []*Backpressure{nil, {MaxInFlight: 2, ErrorRate: 0.5}} {
+		f, _ := newTestFlowControl(config)
+		f.pause("t", []int32{1})
+		allocs := testing.AllocsPerRun(100, func() {
+			f.begin()
+			f.end(nil)
+		})
+		assert.Equal(t, float64(0), allocs)
+	}
+}
+
+func TestFlowControl_ErrorRate(t *testing.T) {
+	f, pauser := newTestFlowControl(&Backpressure{ErrorRate: 0.5, Window: 4, Cooldown: 20 * time.Millisecond})
+	defer f.stop()
+
+	for _, err := range []error{nil, errors.New("a"), nil} {
+		f.begin()
+		f.end(err)
+	}
+	assert.Equal(t, map[int32]bool{}, pauser.get())
+
+	f.begin()
+	f.end(errors.New("b"))
+	assert.Equal(t, map[int32]bool{0: true, 1: true, 2: true}, pauser.get())
+
+	assert.Eventually(t, func() bool { return len(pauser.get()) == 0 }, time.Second, 5*time.Millisecond)
+}
+
+func TestReader_Pause(t *testing.T) {
+	broker := NewMockBroker(2)
+	w := broker.NewWriter(WriterPartitioner(ManualPartitioner))
+	defer w.Close()
+
+	var (
+		mutex    sync.Mutex
+		consumed = make(map[int32]int)
+	)
+	r := broker.NewReader("t", "g")
+	defer r.Close()
+	_ = r.FetchMessage(context.Background(), func(ctx context.Context, session sarama.ConsumerGroupSession, message *ConsumerMessage) error {
+		mutex.Lock()
+		consumed[message.Partition]++
+		mutex.Unlock()
+		return r.CommitMessage(ctx, session, message)
+	})
+	waitIdle(t, broker)
+
+	r.Pause("t", 1)
+	for _, partition := range []int32{0, 1, 0, 1} {
+		_ = w.SendMessage(context.Background(), &ProducerMessage{Topic: "t", Partition: partition, Value: []byte("a")})
+	}
+	waitIdle(t, broker)
+	mutex.Lock()
+	assert.Equal(t, map[int32]int{0: 2}, consumed)
+	mutex.Unlock()
+
+	// The partition stays paused across a rebalance.
+	broker.Rebalance("g")
+	waitIdle(t, broker)
+	r.Resume("t")
+	waitIdle(t, broker)
+	mutex.Lock()
+	assert.Equal(t, map[int32]int{0: 2, 1: 2}, consumed)
+	mutex.Unlock()
+}
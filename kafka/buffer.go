@@ -0,0 +1,595 @@
+package kafka
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/LabKiko/kiko-gokit/logger"
+	"github.com/Shopify/sarama"
+)
+
+const (
+	defaultBufferMaxBytes      = 1 << 30
+	defaultBufferSegmentBytes  = 16 << 20
+	defaultBufferRetryInterval = time.Second
+
+	bufferSegmentExt  = ".log"
+	bufferHeadFile    = "head"
+	bufferHeaderSize  = 8
+	bufferMaxRecordSz = 64 << 20
+)
+
+var (
+	// ErrBufferFull is returned by BufferedWriter.SendMessage when the
+	// message would not fit in the buffer.
+	ErrBufferFull = errors.New("kafka: write-ahead buffer is full")
+
+	errBufferCorrupted = errors.New("kafka: corrupted write-ahead buffer record")
+)
+
+type BufferOpts struct {
+	// MaxBytes bounds the disk usage of the buffer, default 1GiB.
+	MaxBytes int64
+
+	// SegmentBytes is the size above which a new segment file is started,
+	// the fully replayed segments are deleted. Default 16MiB.
+	SegmentBytes int64
+
+	// RetryInterval is the interval between two replays of the backlog,
+	// default 1s.
+	RetryInterval time.Duration
+
+	// Sync fsyncs the segment after every buffered message.
+	Sync bool
+
+	// Retriable reports whether a message that failed with err should be
+	// buffered and retried, by default every error but the ones the
+	// brokers would return again, e.g. sarama.ErrMessageSizeTooLarge.
+	Retriable func(err error) bool
+
+	Logger logger.Logger
+}
+
+type BufferOpt func(o *BufferOpts)
+
+func newBufferOptions(opts ...BufferOpt) BufferOpts {
+	opt := BufferOpts{
+		MaxBytes:      defaultBufferMaxBytes,
+		SegmentBytes:  defaultBufferSegmentBytes,
+		RetryInterval: defaultBufferRetryInterval,
+		Retriable:     isRetriable,
+	}
+	for _, o := range opts {
+		o(&opt)
+	}
+	if opt.Logger == nil {
+		opt.Logger = logger.New(
+			logger.WithNamespace("kafka"),
+			logger.WithConsole(true),
+			logger.WithDisableDisk(true),
+			logger.WithFields(map[string]interface{}{
+				"event": producerEvent,
+			}),
+		)
+	}
+	return opt
+}
+
+// BufferMaxBytes bounds the disk usage of the buffer.
+func BufferMaxBytes(bytes int64) BufferOpt {
+	return func(o *BufferOpts) {
+		o.MaxBytes = bytes
+	}
+}
+
+// BufferSegmentBytes sets the size of the segment files.
+func BufferSegmentBytes(bytes int64) BufferOpt {
+	return func(o *BufferOpts) {
+		o.SegmentBytes = bytes
+	}
+}
+
+// BufferRetryInterval sets the interval between two replays of the backlog.
+func BufferRetryInterval(interval time.Duration) BufferOpt {
+	return func(o *BufferOpts) {
+		o.RetryInterval = interval
+	}
+}
+
+// BufferSync fsyncs the segment after every buffered message.
+func BufferSync(sync bool) BufferOpt {
+	return func(o *BufferOpts) {
+		o.Sync = sync
+	}
+}
+
+// BufferRetriable sets which send errors are buffered and retried.
+func BufferRetriable(fn func(err error) bool) BufferOpt {
+	return func(o *BufferOpts) {
+		o.Retriable = fn
+	}
+}
+
+func BufferLogger(logger logger.Logger) BufferOpt {
+	return func(o *BufferOpts) {
+		o.Logger = logger
+	}
+}
+
+// isRetriable returns false for the errors the brokers would return again.
+func isRetriable(err error) bool {
+	var kerr sarama.KError
+	if errors.As(err, &kerr) {
+		switch kerr {
+		case sarama.ErrMessageSizeTooLarge, sarama.ErrInvalidMessage, sarama.ErrInvalidTopic,
+			sarama.ErrTopicAuthorizationFailed:
+			return false
+		}
+	}
+	var cerr sarama.ConfigurationError
+	return !errors.As(err, &cerr)
+}
+
+// BufferedWriter is a Writer persisting to disk the messages the wrapped
+// writer fails to send, they are replayed in order in the background once
+// the brokers are reachable again, including after a restart.
+//
+// While a backlog exists the new messages are appended to it rather than
+// sent, so that the order is preserved. The wrapped writer must be
+// synchronous, the failures reported asynchronously are not buffered.
+type BufferedWriter struct {
+	writer Writer
+	dir    string
+	opts   BufferOpts
+
+	mutex    sync.Mutex
+	closed   bool
+	segments []*bufferSegment
+	head     int64 // read position in segments[0]
+	pending  int
+	size     int64
+
+	closing chan struct{}
+	done    chan struct{}
+}
+
+type bufferSegment struct {
+	seq  int64
+	file *os.File
+	size int64
+}
+
+// bufferedMessage is the on-disk form of a ProducerMessage.
+type bufferedMessage struct {
+	Topic     string          `json:"topic"`
+	Key       string          `json:"key,omitempty"`
+	Value     []byte          `json:"value,omitempty"`
+	Headers   []*RecordHeader `json:"headers,omitempty"`
+	Partition int32           `json:"partition,omitempty"`
+	Timestamp time.Time       `json:"timestamp"`
+	MessageID string          `json:"message_id,omitempty"`
+}
+
+// NewBufferedWriter wraps w with a write-ahead buffer stored in dir, the
+// backlog left in dir by a previous run is replayed.
+func NewBufferedWriter(w Writer, dir string, opts ...BufferOpt) (*BufferedWriter, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	b := &BufferedWriter{
+		writer:  w,
+		dir:     dir,
+		opts:    newBufferOptions(opts...),
+		closing: make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	if err := b.open(); err != nil {
+		b.closeSegments()
+		return nil, err
+	}
+
+	go b.replayLoop()
+
+	return b, nil
+}
+
+func (b *BufferedWriter) SendMessage(ctx context.Context, message *ProducerMessage) error {
+	b.mutex.Lock()
+	if b.closed {
+		b.mutex.Unlock()
+		return io.ErrClosedPipe
+	}
+
+	if b.pending == 0 {
+		b.mutex.Unlock()
+		err := b.writer.SendMessage(ctx, message)
+		if err == nil || !b.opts.Retriable(err) {
+			return err
+		}
+		b.opts.Logger.Warnf("send to %s failed, buffering: %v", message.Topic, err)
+
+		b.mutex.Lock()
+		if b.closed {
+			b.mutex.Unlock()
+			return err
+		}
+	}
+	defer b.mutex.Unlock()
+
+	return b.appendLocked(message)
+}
+
+func (b *BufferedWriter) Errors() <-chan *ProducerError {
+	return b.writer.Errors()
+}
+
+func (b *BufferedWriter) Messages() <-chan *ProducerMessage {
+	return b.writer.Messages()
+}
+
+// Backlog returns the number and the size in bytes of the buffered messages
+// waiting to be replayed.
+func (b *BufferedWriter) Backlog() (messages int, bytes int64) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	return b.pending, b.size - b.head
+}
+
+// Close stops the replay and closes the wrapped writer, the backlog is kept
+// on disk for the next run.
+func (b *BufferedWriter) Close() error {
+	b.mutex.Lock()
+	if b.closed {
+		b.mutex.Unlock()
+		return nil
+	}
+	b.closed = true
+	b.mutex.Unlock()
+
+	close(b.closing)
+	<-b.done
+
+	b.mutex.Lock()
+	b.closeSegments()
+	b.mutex.Unlock()
+
+	return b.writer.Close()
+}
+
+// open loads the segments left in the directory, counts the backlog and
+// truncates the records left incomplete by a crash.
+func (b *BufferedWriter) open() error {
+	entries, err := os.ReadDir(b.dir)
+	if err != nil {
+		return err
+	}
+	seqs := make([]int64, 0, len(entries))
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, bufferSegmentExt) {
+			continue
+		}
+		seq, err := strconv.ParseInt(strings.TrimSuffix(name, bufferSegmentExt), 10, 64)
+		if err != nil {
+			continue
+		}
+		seqs = append(seqs, seq)
+	}
+	sort.Slice(seqs, func(i, j int) bool { return seqs[i] < seqs[j] })
+
+	headSeq, headPos := b.readHead()
+	for _, seq := range seqs {
+		if seq < headSeq {
+			if err := os.Remove(b.segmentPath(seq)); err != nil {
+				return err
+			}
+			continue
+		}
+
+		file, err := os.OpenFile(b.segmentPath(seq), os.O_RDWR, 0o644)
+		if err != nil {
+			return err
+		}
+		info, err := file.Stat()
+		if err != nil {
+			_ = file.Close()
+			return err
+		}
+		segment := &bufferSegment{seq: seq, file: file, size: info.Size()}
+		b.segments = append(b.segments, segment)
+
+		pos := int64(0)
+		if seq == headSeq && headPos <= segment.size {
+			pos, b.head = headPos, headPos
+		}
+		for {
+			_, next, err := readBufferRecord(file, pos)
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				b.opts.Logger.Warnf("truncate buffer segment %s at %d: %v", file.Name(), pos, err)
+				if err := file.Truncate(pos); err != nil {
+					return err
+				}
+				segment.size = pos
+				break
+			}
+			pos = next
+			b.pending++
+		}
+		b.size += segment.size
+	}
+
+	if len(b.segments) == 0 {
+		return b.rotateLocked()
+	}
+	return nil
+}
+
+func (b *BufferedWriter) appendLocked(message *ProducerMessage) error {
+	payload, err := json.Marshal(&bufferedMessage{
+		Topic:     message.Topic,
+		Key:       message.Key,
+		Value:     message.Value,
+		Headers:   message.Headers,
+		Partition: message.Partition,
+		Timestamp: message.Timestamp,
+		MessageID: message.MessageID,
+	})
+	if err != nil {
+		return err
+	}
+	record := make([]byte, bufferHeaderSize+len(payload))
+	binary.BigEndian.PutUint32(record[0:4], uint32(len(payload)))
+	binary.BigEndian.PutUint32(record[4:8], crc32.ChecksumIEEE(payload))
+	copy(record[bufferHeaderSize:], payload)
+
+	if b.size+int64(len(record)) > b.opts.MaxBytes {
+		return ErrBufferFull
+	}
+
+	segment := b.segments[len(b.segments)-1]
+	if segment.size > 0 && segment.size+int64(len(record)) > b.opts.SegmentBytes {
+		if err := b.rotateLocked(); err != nil {
+			return err
+		}
+		segment = b.segments[len(b.segments)-1]
+	}
+
+	if _, err := segment.file.WriteAt(record, segment.size); err != nil {
+		return err
+	}
+	if b.opts.Sync {
+		if err := segment.file.Sync(); err != nil {
+			return err
+		}
+	}
+	segment.size += int64(len(record))
+	b.size += int64(len(record))
+	b.pending++
+	return nil
+}
+
+// rotateLocked starts a new segment.
+func (b *BufferedWriter) rotateLocked() error {
+	seq := int64(1)
+	if len(b.segments) > 0 {
+		seq = b.segments[len(b.segments)-1].seq + 1
+	}
+	file, err := os.OpenFile(b.segmentPath(seq), os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	b.segments = append(b.segments, &bufferSegment{seq: seq, file: file})
+	return nil
+}
+
+func (b *BufferedWriter) replayLoop() {
+	defer close(b.done)
+
+	ticker := time.NewTicker(b.opts.RetryInterval)
+	defer ticker.Stop()
+	for {
+		b.replay()
+		select {
+		case <-b.closing:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// replay sends the backlog in order until it is empty or a send fails.
+func (b *BufferedWriter) replay() {
+	for {
+		select {
+		case <-b.closing:
+			return
+		default:
+		}
+
+		message, next, ok := b.peek()
+		if !ok {
+			return
+		}
+		if err := b.writer.SendMessage(context.Background(), message); err != nil {
+			if b.opts.Retriable(err) {
+				b.opts.Logger.Debugf("replay to %s failed, retrying in %s: %v", message.Topic, b.opts.RetryInterval, err)
+				return
+			}
+			b.opts.Logger.Errorf("replay to %s failed, dropping message %s: %v", message.Topic, message.MessageID, err)
+		}
+		b.advance(next)
+	}
+}
+
+// peek returns the oldest buffered message and the position following it.
+func (b *BufferedWriter) peek() (*ProducerMessage, int64, bool) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	for b.pending > 0 {
+		segment := b.segments[0]
+		if b.head >= segment.size && len(b.segments) > 1 {
+			b.dropHeadLocked()
+			continue
+		}
+
+		record, next, err := readBufferRecord(segment.file, b.head)
+		if err != nil {
+			b.opts.Logger.Errorf("skip buffer segment %s from %d: %v", segment.file.Name(), b.head, err)
+			b.head = segment.size
+			b.recountLocked()
+			continue
+		}
+
+		message := &ProducerMessage{
+			Topic:     record.Topic,
+			Key:       record.Key,
+			Value:     record.Value,
+			Headers:   record.Headers,
+			Partition: record.Partition,
+			Timestamp: record.Timestamp,
+			MessageID: record.MessageID,
+		}
+		return message, next, true
+	}
+	return nil, 0, false
+}
+
+// advance moves the read position past a replayed message.
+func (b *BufferedWriter) advance(next int64) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.head = next
+	b.pending--
+	if b.pending == 0 {
+		// Reclaim the disk once the backlog is empty.
+		for len(b.segments) > 1 {
+			b.dropHeadLocked()
+		}
+		segment := b.segments[0]
+		if err := segment.file.Truncate(0); err != nil {
+			b.opts.Logger.Errorf("truncate buffer segment %s: %v", segment.file.Name(), err)
+		} else {
+			b.size -= segment.size
+			segment.size = 0
+			b.head = 0
+		}
+	}
+	if err := b.writeHead(); err != nil {
+		b.opts.Logger.Errorf("write buffer head: %v", err)
+	}
+}
+
+// dropHeadLocked deletes the first segment, which was fully replayed.
+func (b *BufferedWriter) dropHeadLocked() {
+	segment := b.segments[0]
+	_ = segment.file.Close()
+	if err := os.Remove(segment.file.Name()); err != nil {
+		b.opts.Logger.Errorf("remove buffer segment %s: %v", segment.file.Name(), err)
+	}
+	b.size -= segment.size
+	b.segments = b.segments[1:]
+	b.head = 0
+}
+
+// recountLocked counts the messages following the read position.
+func (b *BufferedWriter) recountLocked() {
+	b.pending = 0
+	for i, segment := range b.segments {
+		pos := int64(0)
+		if i == 0 {
+			pos = b.head
+		}
+		for {
+			_, next, err := readBufferRecord(segment.file, pos)
+			if err != nil {
+				break
+			}
+			pos = next
+			b.pending++
+		}
+	}
+}
+
+func (b *BufferedWriter) closeSegments() {
+	for _, segment := range b.segments {
+		_ = segment.file.Close()
+	}
+}
+
+func (b *BufferedWriter) segmentPath(seq int64) string {
+	return filepath.Join(b.dir, fmt.Sprintf("%020d%s", seq, bufferSegmentExt))
+}
+
+// readHead returns the read position persisted by writeHead.
+func (b *BufferedWriter) readHead() (seq, pos int64) {
+	data, err := os.ReadFile(filepath.Join(b.dir, bufferHeadFile))
+	if err != nil {
+		return 0, 0
+	}
+	if _, err := fmt.Sscanf(string(data), "%d %d", &seq, &pos); err != nil {
+		return 0, 0
+	}
+	return seq, pos
+}
+
+// writeHead persists the read position, so that a restart does not replay
+// the messages already sent.
+func (b *BufferedWriter) writeHead() error {
+	path := filepath.Join(b.dir, bufferHeadFile)
+	tmp := path + ".tmp"
+	data := fmt.Sprintf("%d %d", b.segments[0].seq, b.head)
+	if err := os.WriteFile(tmp, []byte(data), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// readBufferRecord reads the record at pos, io.EOF is returned at the end
+// of the segment.
+func readBufferRecord(file *os.File, pos int64) (*bufferedMessage, int64, error) {
+	header := make([]byte, bufferHeaderSize)
+	n, err := file.ReadAt(header, pos)
+	if n == 0 && err == io.EOF {
+		return nil, 0, io.EOF
+	}
+	if n < bufferHeaderSize {
+		return nil, 0, errBufferCorrupted
+	}
+
+	length := binary.BigEndian.Uint32(header[0:4])
+	if length > bufferMaxRecordSz {
+		return nil, 0, errBufferCorrupted
+	}
+	payload := make([]byte, length)
+	if _, err := file.ReadAt(payload, pos+bufferHeaderSize); err != nil {
+		return nil, 0, errBufferCorrupted
+	}
+	if crc32.ChecksumIEEE(payload) != binary.BigEndian.Uint32(header[4:8]) {
+		return nil, 0, errBufferCorrupted
+	}
+
+	record := &bufferedMessage{}
+	if err := json.Unmarshal(payload, record); err != nil {
+		return nil, 0, errBufferCorrupted
+	}
+	return record, pos + bufferHeaderSize + int64(length), nil
+}
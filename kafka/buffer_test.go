@@ -0,0 +1,114 @@
+package kafka
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/stretchr/testify/assert"
+)
+
+func sendValues(t *testing.T, w Writer, values ...string) {
+	t.Helper()
+	for _, value := range values {
+		if err := w.SendMessage(context.Background(), &ProducerMessage{Topic: "events", Key: "k", Value: []byte(value)}); err != nil {
+			t.Fatalf("SendMessage(%s) error = %v", value, err)
+		}
+	}
+}
+
+func topicValues(broker *MockBroker, topic string) []string {
+	values := make([]string, 0)
+	for _, message := range broker.Messages(topic) {
+		values = append(values, string(message.Value))
+	}
+	return values
+}
+
+func TestBufferedWriter_Replay(t *testing.T) {
+	broker := NewMockBroker(1)
+	w, err := NewBufferedWriter(broker.NewWriter(), t.TempDir(), BufferRetryInterval(10*time.Millisecond), BufferSegmentBytes(256))
+	assert.Nil(t, err)
+	defer w.Close()
+
+	sendValues(t, w, "a")
+	broker.SetProduceError(sarama.ErrOutOfBrokers)
+	sendValues(t, w, "b", "c", "d", "e")
+	messages, bytes := w.Backlog()
+	assert.Equal(t, 4, messages)
+	assert.Greater(t, bytes, int64(0))
+
+	broker.SetProduceError(nil)
+	// Sent after the backlog although the brokers are back.
+	sendValues(t, w, "f")
+	assert.Eventually(t, func() bool {
+		messages, _ := w.Backlog()
+		return messages == 0
+	}, time.Second, 5*time.Millisecond)
+
+	assert.Equal(t, []string{"a", "b", "c", "d", "e", "f"}, topicValues(broker, "events"))
+	for _, message := range broker.Messages("events") {
+		assert.Equal(t, "k", string(message.Key))
+		assert.NotEmpty(t, message.Header(MessageIDHeader))
+	}
+	_, bytes = w.Backlog()
+	assert.Equal(t, int64(0), bytes)
+}
+
+func TestBufferedWriter_Restart(t *testing.T) {
+	dir := t.TempDir()
+	broker := NewMockBroker(1)
+	broker.SetProduceError(sarama.ErrOutOfBrokers)
+
+	w, err := NewBufferedWriter(broker.NewWriter(), dir, BufferRetryInterval(time.Hour), BufferSegmentBytes(128))
+	assert.Nil(t, err)
+	sendValues(t, w, "a", "b", "c", "d")
+	assert.Nil(t, w.Close())
+
+	// A crash left an incomplete record at the end of the last segment.
+	segments, _ := filepath.Glob(filepath.Join(dir, "*.log"))
+	assert.Greater(t, len(segments), 1)
+	f, err := os.OpenFile(segments[len(segments)-1], os.O_APPEND|os.O_WRONLY, 0o644)
+	assert.Nil(t, err)
+	_, _ = f.Write([]byte{0, 0, 1})
+	_ = f.Close()
+
+	broker.SetProduceError(nil)
+	w, err = NewBufferedWriter(broker.NewWriter(), dir, BufferRetryInterval(10*time.Millisecond), BufferSegmentBytes(128))
+	assert.Nil(t, err)
+	defer w.Close()
+	assert.Eventually(t, func() bool {
+		messages, _ := w.Backlog()
+		return messages == 0
+	}, time.Second, 5*time.Millisecond)
+	assert.Equal(t, []string{"a", "b", "c", "d"}, topicValues(broker, "events"))
+
+	segments, _ = filepath.Glob(filepath.Join(dir, "*.log"))
+	assert.Len(t, segments, 1)
+}
+
+func TestBufferedWriter_Limits(t *testing.T) {
+	broker := NewMockBroker(1)
+	w, err := NewBufferedWriter(broker.NewWriter(), t.TempDir(), BufferRetryInterval(time.Hour), BufferMaxBytes(300))
+	assert.Nil(t, err)
+	defer w.Close()
+
+	// Errors the brokers would return again are not buffered.
+	broker.SetProduceError(sarama.ErrMessageSizeTooLarge)
+	assert.ErrorIs(t, w.SendMessage(context.Background(), &ProducerMessage{Topic: "events", Value: []byte("a")}), sarama.ErrMessageSizeTooLarge)
+	messages, _ := w.Backlog()
+	assert.Equal(t, 0, messages)
+
+	broker.SetProduceError(sarama.ErrOutOfBrokers)
+	var full error
+	for i := 0; i < 10 && full == nil; i++ {
+		full = w.SendMessage(context.Background(), &ProducerMessage{Topic: "events", Value: []byte(fmt.Sprint(i))})
+	}
+	assert.ErrorIs(t, full, ErrBufferFull)
+	_, bytes := w.Backlog()
+	assert.LessOrEqual(t, bytes, int64(300))
+}
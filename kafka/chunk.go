@@ -0,0 +1,305 @@
+package kafka
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/LabKiko/kiko-gokit/logger"
+	"github.com/Shopify/sarama"
+	uuid "github.com/satori/go.uuid"
+)
+
+const (
+	// ChunkIDHeader is the header carrying the MessageID of the message a
+	// chunk belongs to.
+	ChunkIDHeader = "chunk-id"
+	// ChunkIndexHeader is the header carrying the index of a chunk, from 0.
+	ChunkIndexHeader = "chunk-index"
+	// ChunkCountHeader is the header carrying the number of chunks of a message.
+	ChunkCountHeader = "chunk-count"
+
+	chunkHeaderPrefix = "chunk-"
+
+	defaultReassembleMaxBytes = 64 << 20 // 64MiB
+	defaultReassembleTimeout  = 5 * time.Minute
+)
+
+// Chunk splits the values larger than size bytes across several messages,
+// sent in order with the key of the message, or its MessageID when unset.
+// The chunks of a message are written to the same partition: the one of
+// their key with the RandomPartitioner and RoundRobinPartitioner, which
+// hash it for the chunks, or with a key-based partitioner, the one of
+// ProducerMessage.Partition with the ManualPartitioner. Reassemble restores
+// the messages on the reader side.
+//
+// Each chunk has its own MessageID, the MessageID of the message being
+// carried by the ChunkIDHeader. A failure leaves the chunks already sent
+// incomplete, they are dropped by Reassemble once timed out.
+func Chunk(size int) Interceptor {
+	return func(next SendFunc) SendFunc {
+		return func(ctx context.Context, message *ProducerMessage) error {
+			if len(message.Value) <= size || message.Header(ChunkIDHeader) != "" {
+				return next(ctx, message)
+			}
+
+			if message.MessageID == "" {
+				message.MessageID = uuid.NewV4().String()
+			}
+			key := message.Key
+			if key == "" {
+				key = message.MessageID
+			}
+			count := (len(message.Value) + size - 1) / size
+			for i := 0; i < count; i++ {
+				end := (i + 1) * size
+				if end > len(message.Value) {
+					end = len(message.Value)
+				}
+				chunk := &ProducerMessage{
+					Topic:     message.Topic,
+					Key:       key,
+					Value:     message.Value[i*size : end],
+					Headers:   make([]*RecordHeader, 0, len(message.Headers)+3),
+					Metadata:  message.Metadata,
+					Partition: message.Partition,
+					Timestamp: message.Timestamp,
+					MessageID: message.MessageID + "-" + strconv.Itoa(i),
+				}
+				for _, h := range message.Headers {
+					chunk.Headers = append(chunk.Headers, &RecordHeader{Key: h.Key, Value: h.Value})
+				}
+				chunk.SetHeader(ChunkIDHeader, message.MessageID)
+				chunk.SetHeader(ChunkIndexHeader, strconv.Itoa(i))
+				chunk.SetHeader(ChunkCountHeader, strconv.Itoa(count))
+				if err := next(ctx, chunk); err != nil {
+					return err
+				}
+				message.Partition, message.Offset, message.Timestamp = chunk.Partition, chunk.Offset, chunk.Timestamp
+			}
+			return nil
+		}
+	}
+}
+
+type ReassembleOpts struct {
+	// MaxBytes bounds the size of the incomplete messages held in memory,
+	// the oldest ones are dropped beyond, default 64MiB.
+	MaxBytes int64
+
+	// Timeout is how long an incomplete message waits for its missing
+	// chunks before being dropped, default 5m.
+	Timeout time.Duration
+
+	Logger logger.Logger
+}
+
+type ReassembleOpt func(o *ReassembleOpts)
+
+func newReassembleOptions(opts ...ReassembleOpt) ReassembleOpts {
+	opt := ReassembleOpts{
+		MaxBytes: defaultReassembleMaxBytes,
+		Timeout:  defaultReassembleTimeout,
+	}
+	for _, o := range opts {
+		o(&opt)
+	}
+	if opt.Logger == nil {
+		opt.Logger = logger.New(
+			logger.WithNamespace("kafka"),
+			logger.WithConsole(true),
+			logger.WithDisableDisk(true),
+			logger.WithFields(map[string]interface{}{
+				"event": consumerEvent,
+			}),
+		)
+	}
+	return opt
+}
+
+// ReassembleMaxBytes bounds the size of the incomplete messages held in memory.
+func ReassembleMaxBytes(max int64) ReassembleOpt {
+	return func(o *ReassembleOpts) {
+		o.MaxBytes = max
+	}
+}
+
+// ReassembleTimeout sets how long an incomplete message waits for its chunks.
+func ReassembleTimeout(timeout time.Duration) ReassembleOpt {
+	return func(o *ReassembleOpts) {
+		o.Timeout = timeout
+	}
+}
+
+func ReassembleLogger(logger logger.Logger) ReassembleOpt {
+	return func(o *ReassembleOpts) {
+		o.Logger = logger
+	}
+}
+
+// Reassemble passes the messages split by Chunk to the handler once all
+// their chunks were received, with the headers, MessageID and offset of the
+// last chunk. The offsets marked on the session do not move past the first
+// chunk of an incomplete message, so that it is received again after a
+// restart.
+func Reassemble(opts ...ReassembleOpt) Middleware {
+	r := &reassembler{
+		opts:    newReassembleOptions(opts...),
+		pending: make(map[string]*chunkedMessage),
+	}
+	return func(next Handler) Handler {
+		return func(ctx context.Context, session sarama.ConsumerGroupSession, message *ConsumerMessage) error {
+			session = &chunkSession{ConsumerGroupSession: session, r: r}
+			id := message.Header(ChunkIDHeader)
+			if id == "" {
+				return next(ctx, session, message)
+			}
+
+			whole := r.add(id, message)
+			if whole == nil {
+				return nil
+			}
+			return next(ctx, session, whole)
+		}
+	}
+}
+
+type reassembler struct {
+	opts ReassembleOpts
+
+	mutex   sync.Mutex
+	pending map[string]*chunkedMessage
+	bytes   int64
+}
+
+type chunkedMessage struct {
+	id        string
+	topic     string
+	partition int32
+	first     int64 // offset of the first chunk received
+	chunks    [][]byte
+	received  int
+	bytes     int64
+	started   time.Time
+}
+
+// add records a chunk and returns the reassembled message once complete.
+func (r *reassembler) add(id string, message *ConsumerMessage) *ConsumerMessage {
+	index, err1 := strconv.Atoi(message.Header(ChunkIndexHeader))
+	count, err2 := strconv.Atoi(message.Header(ChunkCountHeader))
+	if err1 != nil || err2 != nil || count <= 0 || index < 0 || index >= count {
+		r.opts.Logger.Errorf("drop invalid chunk %s at %s/%d/%d", id, message.Topic, message.Partition, message.Offset)
+		return nil
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.expireLocked()
+	pending, ok := r.pending[id]
+	if !ok {
+		pending = &chunkedMessage{
+			id:        id,
+			topic:     message.Topic,
+			partition: message.Partition,
+			first:     message.Offset,
+			chunks:    make([][]byte, count),
+			started:   time.Now(),
+		}
+		r.pending[id] = pending
+	}
+	// Redelivered chunks are ignored.
+	if index >= len(pending.chunks) || pending.chunks[index] != nil {
+		return nil
+	}
+	pending.chunks[index] = message.Value
+	pending.received++
+	pending.bytes += int64(len(message.Value))
+	r.bytes += int64(len(message.Value))
+
+	if pending.received < len(pending.chunks) {
+		r.evictLocked()
+		return nil
+	}
+
+	r.removeLocked(pending)
+	value := make([]byte, 0, pending.bytes)
+	for _, chunk := range pending.chunks {
+		value = append(value, chunk...)
+	}
+	whole := *message
+	whole.Value = value
+	whole.Headers = make([]*RecordHeader, 0, len(message.Headers))
+	for _, h := range message.Headers {
+		key := string(h.Key)
+		if strings.HasPrefix(key, chunkHeaderPrefix) {
+			continue
+		}
+		if key == MessageIDHeader {
+			h = &RecordHeader{Key: h.Key, Value: []byte(id)}
+		}
+		whole.Headers = append(whole.Headers, h)
+	}
+	return &whole
+}
+
+func (r *reassembler) removeLocked(pending *chunkedMessage) {
+	delete(r.pending, pending.id)
+	r.bytes -= pending.bytes
+}
+
+func (r *reassembler) expireLocked() {
+	for _, pending := range r.pending {
+		if time.Since(pending.started) > r.opts.Timeout {
+			r.opts.Logger.Errorf("drop incomplete message %s at %s/%d/%d, %d/%d chunks received", pending.id, pending.topic, pending.partition, pending.first, pending.received, len(pending.chunks))
+			r.removeLocked(pending)
+		}
+	}
+}
+
+func (r *reassembler) evictLocked() {
+	for r.bytes > r.opts.MaxBytes {
+		var oldest *chunkedMessage
+		for _, pending := range r.pending {
+			if oldest == nil || pending.started.Before(oldest.started) {
+				oldest = pending
+			}
+		}
+		r.opts.Logger.Errorf("drop incomplete message %s at %s/%d/%d, reassembly buffer full", oldest.id, oldest.topic, oldest.partition, oldest.first)
+		r.removeLocked(oldest)
+	}
+}
+
+// safeOffset returns the offset of the first chunk of the incomplete
+// messages of a partition.
+func (r *reassembler) safeOffset(topic string, partition int32) (int64, bool) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	offset, ok := int64(0), false
+	for _, pending := range r.pending {
+		if pending.topic == topic && pending.partition == partition && (!ok || pending.first < offset) {
+			offset, ok = pending.first, true
+		}
+	}
+	return offset, ok
+}
+
+// chunkSession keeps the marked offsets before the incomplete messages.
+type chunkSession struct {
+	sarama.ConsumerGroupSession
+	r *reassembler
+}
+
+func (s *chunkSession) MarkOffset(topic string, partition int32, offset int64, metadata string) {
+	if safe, ok := s.r.safeOffset(topic, partition); ok && safe < offset {
+		offset = safe
+	}
+	s.ConsumerGroupSession.MarkOffset(topic, partition, offset, metadata)
+}
+
+func (s *chunkSession) MarkMessage(msg *sarama.ConsumerMessage, metadata string) {
+	s.MarkOffset(msg.Topic, msg.Partition, msg.Offset+1, metadata)
+}
@@ -0,0 +1,106 @@
+package kafka
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/Shopify/sarama"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChunk(t *testing.T) {
+	broker := NewMockBroker(1)
+	w := broker.NewWriter(WriterInterceptor(Chunk(4)))
+	defer w.Close()
+
+	large := &ProducerMessage{Topic: "t", Key: "k", Value: []byte("0123456789")}
+	large.SetHeader("content-type", "text/plain")
+	assert.Nil(t, w.SendMessage(context.Background(), large))
+	assert.Nil(t, w.SendMessage(context.Background(), &ProducerMessage{Topic: "t", Value: []byte("abc")}))
+
+	chunks := broker.Messages("t")
+	assert.Len(t, chunks, 4)
+	assert.Equal(t, "89", string(chunks[2].Value))
+	assert.Equal(t, "3", chunks[2].Header(ChunkCountHeader))
+	assert.Equal(t, large.MessageID, chunks[0].Header(ChunkIDHeader))
+	assert.NotEqual(t, chunks[0].Header(MessageIDHeader), chunks[1].Header(MessageIDHeader))
+
+	var values []string
+	var whole *ConsumerMessage
+	r := broker.NewReader("t", "g", ReaderMiddleware(Reassemble()))
+	defer r.Close()
+	_ = r.FetchMessage(context.Background(), func(ctx context.Context, session sarama.ConsumerGroupSession, message *ConsumerMessage) error {
+		values = append(values, string(message.Value))
+		if bytes.Equal(message.Value, large.Value) {
+			whole = message
+		}
+		return r.CommitMessage(ctx, session, message)
+	})
+	waitIdle(t, broker)
+	assert.Equal(t, []string{"0123456789", "abc"}, values)
+	assert.Equal(t, large.MessageID, whole.Header(MessageIDHeader))
+	assert.Equal(t, "text/plain", whole.Header("content-type"))
+	assert.Empty(t, whole.Header(ChunkIDHeader))
+	assert.Equal(t, int64(4), broker.Committed("g", "t", 0))
+}
+
+func TestChunk_Partitions(t *testing.T) {
+	for _, partitioner := range []Partitioner{RandomPartitioner, RoundRobinPartitioner, HashPartitioner} {
+		broker := NewMockBroker(8)
+		w := broker.NewWriter(WriterPartitioner(partitioner), WriterInterceptor(Chunk(2)))
+
+		for i := 0; i < 20; i++ {
+			assert.Nil(t, w.SendMessage(context.Background(), &ProducerMessage{Topic: "t", Value: []byte("0123456789")}))
+		}
+		assert.Nil(t, w.Close())
+
+		// The chunks of a message share their partition.
+		partitions := make(map[string]int32)
+		for _, chunk := range broker.Messages("t") {
+			id := chunk.Header(ChunkIDHeader)
+			if partition, ok := partitions[id]; ok {
+				assert.Equal(t, partition, chunk.Partition, "partitioner %d", partitioner)
+			}
+			partitions[id] = chunk.Partition
+		}
+		assert.Len(t, partitions, 20)
+
+		var values int
+		r := broker.NewReader("t", "g", ReaderMiddleware(Reassemble()))
+		_ = r.FetchMessage(context.Background(), func(ctx context.Context, session sarama.ConsumerGroupSession, message *ConsumerMessage) error {
+			assert.Equal(t, "0123456789", string(message.Value))
+			values++
+			return r.CommitMessage(ctx, session, message)
+		})
+		waitIdle(t, broker)
+		assert.Nil(t, r.Close())
+		assert.Equal(t, 20, values)
+	}
+}
+
+func TestReassemble_Incomplete(t *testing.T) {
+	broker := NewMockBroker(1)
+	w := broker.NewWriter()
+	defer w.Close()
+
+	// The producer failed after the first chunk of "a", then sent "b".
+	chunk := &ProducerMessage{Topic: "t", Value: []byte("a0")}
+	chunk.SetHeader(ChunkIDHeader, "a")
+	chunk.SetHeader(ChunkIndexHeader, "0")
+	chunk.SetHeader(ChunkCountHeader, "2")
+	assert.Nil(t, w.SendMessage(context.Background(), chunk))
+	assert.Nil(t, w.SendMessage(context.Background(), &ProducerMessage{Topic: "t", Value: []byte("b")}))
+
+	var values []string
+	r := broker.NewReader("t", "g", ReaderMiddleware(Reassemble()))
+	defer r.Close()
+	_ = r.FetchMessage(context.Background(), func(ctx context.Context, session sarama.ConsumerGroupSession, message *ConsumerMessage) error {
+		values = append(values, string(message.Value))
+		return r.CommitMessage(ctx, session, message)
+	})
+	waitIdle(t, broker)
+	assert.Equal(t, []string{"b"}, values)
+	// The commit stays at the first chunk of the incomplete message.
+	assert.Equal(t, int64(0), broker.Committed("g", "t", 0))
+}
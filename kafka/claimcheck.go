@@ -0,0 +1,142 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+
+	"github.com/Shopify/sarama"
+	uuid "github.com/satori/go.uuid"
+)
+
+// ClaimCheckHeader is the header carrying the key of the payload stored in
+// a BlobStore in place of the value of a message.
+const ClaimCheckHeader = "claim-check"
+
+// ErrBlobNotFound is returned by a BlobStore getting a missing key.
+var ErrBlobNotFound = errors.New("kafka: blob not found")
+
+// BlobStore stores the payloads of the messages sent by ClaimCheck.
+// Implementations must be safe for concurrent use.
+type BlobStore interface {
+	Put(ctx context.Context, key string, value []byte) error
+	// Get returns ErrBlobNotFound when key is missing.
+	Get(ctx context.Context, key string) ([]byte, error)
+	Delete(ctx context.Context, key string) error
+}
+
+// FileBlobStore is a BlobStore keeping one file per payload in a directory,
+// e.g. on a volume shared by the producers and the consumers.
+type FileBlobStore struct {
+	dir string
+}
+
+// NewFileBlobStore stores the payloads in dir, which is created if missing.
+func NewFileBlobStore(dir string) (*FileBlobStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &FileBlobStore{dir: dir}, nil
+}
+
+func (s *FileBlobStore) Put(ctx context.Context, key string, value []byte) error {
+	// Written aside and renamed, so that a reader never sees a partial payload.
+	tmp, err := os.CreateTemp(s.dir, ".blob-*")
+	if err != nil {
+		return err
+	}
+	if _, err = tmp.Write(value); err == nil {
+		err = tmp.Sync()
+	}
+	if e := tmp.Close(); err == nil {
+		err = e
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), s.path(key))
+	}
+	if err != nil {
+		_ = os.Remove(tmp.Name())
+	}
+	return err
+}
+
+func (s *FileBlobStore) Get(ctx context.Context, key string) ([]byte, error) {
+	value, err := os.ReadFile(s.path(key))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrBlobNotFound
+	}
+	return value, err
+}
+
+func (s *FileBlobStore) Delete(ctx context.Context, key string) error {
+	if err := os.Remove(s.path(key)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+func (s *FileBlobStore) path(key string) string {
+	return filepath.Join(s.dir, url.PathEscape(key))
+}
+
+// ClaimCheck stores the values larger than threshold bytes in store and
+// sends their key in the ClaimCheckHeader instead, with an empty value.
+// ResolveClaimCheck restores the values on the reader side. The payloads are
+// not deleted, as every consumer group reads them, and must be expired by
+// the store.
+func ClaimCheck(store BlobStore, threshold int) Interceptor {
+	return func(next SendFunc) SendFunc {
+		return func(ctx context.Context, message *ProducerMessage) error {
+			if len(message.Value) <= threshold || message.Header(ClaimCheckHeader) != "" {
+				return next(ctx, message)
+			}
+
+			if message.MessageID == "" {
+				message.MessageID = uuid.NewV4().String()
+			}
+			key := message.Topic + "/" + message.MessageID
+			if err := store.Put(ctx, key, message.Value); err != nil {
+				return fmt.Errorf("kafka: store claim check %s: %w", key, err)
+			}
+
+			claim := *message
+			claim.Value = nil
+			claim.Headers = make([]*RecordHeader, 0, len(message.Headers)+1)
+			for _, h := range message.Headers {
+				claim.Headers = append(claim.Headers, &RecordHeader{Key: h.Key, Value: h.Value})
+			}
+			claim.SetHeader(ClaimCheckHeader, key)
+
+			err := next(ctx, &claim)
+			message.Partition = claim.Partition
+			message.Offset = claim.Offset
+			message.Timestamp = claim.Timestamp
+			return err
+		}
+	}
+}
+
+// ResolveClaimCheck loads the values of the messages sent by ClaimCheck from
+// store, the other messages are passed through. A store failure is returned,
+// so that the message is handled again.
+func ResolveClaimCheck(store BlobStore) Middleware {
+	return func(next Handler) Handler {
+		return func(ctx context.Context, session sarama.ConsumerGroupSession, message *ConsumerMessage) error {
+			key := message.Header(ClaimCheckHeader)
+			if key == "" {
+				return next(ctx, session, message)
+			}
+
+			value, err := store.Get(ctx, key)
+			if err != nil {
+				return fmt.Errorf("kafka: load claim check %s: %w", key, err)
+			}
+			resolved := *message
+			resolved.Value = value
+			return next(ctx, session, &resolved)
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package kafka
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/Shopify/sarama"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClaimCheck(t *testing.T) {
+	store, err := NewFileBlobStore(t.TempDir())
+	assert.Nil(t, err)
+	broker := NewMockBroker(1)
+	w := broker.NewWriter(WriterInterceptor(ClaimCheck(store, 8)))
+	defer w.Close()
+
+	large := strings.Repeat("x", 64)
+	message := &ProducerMessage{Topic: "t", Value: []byte(large)}
+	assert.Nil(t, w.SendMessage(context.Background(), message))
+	assert.Nil(t, w.SendMessage(context.Background(), &ProducerMessage{Topic: "t", Value: []byte("small")}))
+	assert.Equal(t, large, string(message.Value))
+
+	stored := broker.Messages("t")
+	assert.Empty(t, stored[0].Value)
+	key := stored[0].Header(ClaimCheckHeader)
+	assert.Equal(t, "t/"+message.MessageID, key)
+	assert.Empty(t, stored[1].Header(ClaimCheckHeader))
+
+	values := make([]string, 0)
+	r := broker.NewReader("t", "g", ReaderMiddleware(ResolveClaimCheck(store)))
+	defer r.Close()
+	_ = r.FetchMessage(context.Background(), func(ctx context.Context, session sarama.ConsumerGroupSession, message *ConsumerMessage) error {
+		values = append(values, string(message.Value))
+		return r.CommitMessage(ctx, session, message)
+	})
+	waitIdle(t, broker)
+	assert.Equal(t, []string{large, "small"}, values)
+
+	assert.Nil(t, store.Delete(context.Background(), key))
+	_, err = store.Get(context.Background(), key)
+	assert.ErrorIs(t, err, ErrBlobNotFound)
+}
@@ -0,0 +1,162 @@
+package kafka
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+
+	"github.com/Shopify/sarama"
+	"github.com/xdg-go/scram"
+)
+
+// ClientOpts holds the connection settings shared by readers and writers.
+type ClientOpts struct {
+	// Version is the version of the brokers, e.g. "2.8.1", default 3.0.0.
+	Version string
+
+	// ConfigFunc is called last with the generated sarama config, it is an
+	// escape hatch for the settings without a dedicated option.
+	ConfigFunc func(config *sarama.Config)
+
+	// TLS enables TLS towards the brokers when set.
+	TLS *TLSConfig
+
+	// SASL enables SASL authentication towards the brokers when set.
+	SASL *SASLConfig
+
+	// Debug enables the sarama debug logs while the client is open. sarama
+	// logs per process, not per client, see InitLogger.
+	Debug bool
+}
+
+// TLSConfig configures TLS towards the brokers.
+type TLSConfig struct {
+	// CAFile is the PEM encoded CA bundle used to verify the brokers,
+	// the system pool is used when empty.
+	CAFile string
+	// CertFile and KeyFile are the PEM encoded client certificate and key,
+	// used for mutual TLS.
+	CertFile string
+	KeyFile  string
+	// InsecureSkipVerify disables the verification of the broker certificates.
+	InsecureSkipVerify bool
+}
+
+// SASLMechanism is the SASL mechanism used to authenticate.
+type SASLMechanism string
+
+const (
+	SASLPlain       SASLMechanism = sarama.SASLTypePlaintext
+	SASLScramSHA256 SASLMechanism = sarama.SASLTypeSCRAMSHA256
+	SASLScramSHA512 SASLMechanism = sarama.SASLTypeSCRAMSHA512
+)
+
+// SASLConfig configures SASL authentication.
+type SASLConfig struct {
+	Mechanism SASLMechanism
+	Username  string
+	Password  string
+}
+
+// newClientConfig builds the sarama config shared by readers and writers.
+func newClientConfig(serviceName string, opts ClientOpts) (*sarama.Config, error) {
+	config := sarama.NewConfig()
+	config.ClientID = serviceName
+	config.Version = sarama.V3_0_0_0
+	if opts.Version != "" {
+		version, err := sarama.ParseKafkaVersion(opts.Version)
+		if err != nil {
+			return nil, err
+		}
+		config.Version = version
+	}
+
+	if opts.TLS != nil {
+		tlsConfig, err := opts.TLS.build()
+		if err != nil {
+			return nil, err
+		}
+		config.Net.TLS.Enable = true
+		config.Net.TLS.Config = tlsConfig
+	}
+
+	if opts.SASL != nil {
+		config.Net.SASL.Enable = true
+		config.Net.SASL.Handshake = true
+		config.Net.SASL.User = opts.SASL.Username
+		config.Net.SASL.Password = opts.SASL.Password
+		switch opts.SASL.Mechanism {
+		case SASLPlain, "":
+			config.Net.SASL.Mechanism = sarama.SASLTypePlaintext
+		case SASLScramSHA256:
+			config.Net.SASL.Mechanism = sarama.SASLTypeSCRAMSHA256
+			config.Net.SASL.SCRAMClientGeneratorFunc = func() sarama.SCRAMClient {
+				return &scramClient{HashGeneratorFcn: sha256.New}
+			}
+		case SASLScramSHA512:
+			config.Net.SASL.Mechanism = sarama.SASLTypeSCRAMSHA512
+			config.Net.SASL.SCRAMClientGeneratorFunc = func() sarama.SCRAMClient {
+				return &scramClient{HashGeneratorFcn: sha512.New}
+			}
+		default:
+			return nil, fmt.Errorf("kafka: unsupported sasl mechanism: %s", opts.SASL.Mechanism)
+		}
+	}
+
+	return config, nil
+}
+
+func (c *TLSConfig) build() (*tls.Config, error) {
+	config := &tls.Config{
+		InsecureSkipVerify: c.InsecureSkipVerify,
+	}
+
+	if c.CAFile != "" {
+		ca, err := os.ReadFile(c.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("kafka: no certificate found in %s", c.CAFile)
+		}
+		config.RootCAs = pool
+	}
+
+	if c.CertFile != "" || c.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	return config, nil
+}
+
+// scramClient implements sarama.SCRAMClient.
+type scramClient struct {
+	*scram.Client
+	*scram.ClientConversation
+	scram.HashGeneratorFcn
+}
+
+func (c *scramClient) Begin(userName, password, authzID string) (err error) {
+	c.Client, err = c.HashGeneratorFcn.NewClient(userName, password, authzID)
+	if err != nil {
+		return err
+	}
+	c.ClientConversation = c.Client.NewConversation()
+	return nil
+}
+
+func (c *scramClient) Step(challenge string) (string, error) {
+	return c.ClientConversation.Step(challenge)
+}
+
+func (c *scramClient) Done() bool {
+	return c.ClientConversation.Done()
+}
@@ -0,0 +1,32 @@
+package kafka
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/Shopify/sarama"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewClientConfig_SASL(t *testing.T) {
+	config, err := newClientConfig("test", ClientOpts{
+		SASL: &SASLConfig{Mechanism: SASLScramSHA512, Username: "user", Password: "secret"},
+		TLS:  &TLSConfig{InsecureSkipVerify: true},
+	})
+	assert.Nil(t, err)
+	assert.Nil(t, config.Validate())
+	assert.True(t, config.Net.SASL.Enable)
+	assert.True(t, config.Net.TLS.Enable)
+	assert.Equal(t, sarama.SASLMechanism(sarama.SASLTypeSCRAMSHA512), config.Net.SASL.Mechanism)
+	assert.NotNil(t, config.Net.SASL.SCRAMClientGeneratorFunc())
+
+	_, err = newClientConfig("test", ClientOpts{SASL: &SASLConfig{Mechanism: "GSSAPI"}})
+	assert.NotNil(t, err)
+}
+
+func TestNewClientConfig_TLS(t *testing.T) {
+	_, err := newClientConfig("test", ClientOpts{
+		TLS: &TLSConfig{CAFile: filepath.Join(t.TempDir(), "missing.pem")},
+	})
+	assert.NotNil(t, err)
+}
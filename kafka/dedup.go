@@ -0,0 +1,190 @@
+package kafka
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+
+	"github.com/LabKiko/kiko-gokit/logger"
+	"github.com/Shopify/sarama"
+)
+
+// DedupStore records the keys of the messages processed successfully.
+// Implementations must be safe for concurrent use.
+type DedupStore interface {
+	// Seen reports whether the message with key was processed.
+	Seen(ctx context.Context, key string) (bool, error)
+	// Mark records that the message with key was processed.
+	Mark(ctx context.Context, key string) error
+}
+
+type DedupOpts struct {
+	// KeyFunc returns the key identifying a message, the messages with an
+	// empty key are not deduplicated. Default: the topic and the
+	// MessageIDHeader.
+	KeyFunc func(message *ConsumerMessage) string
+
+	// Metrics counts the skipped duplicates when set.
+	Metrics *Metrics
+
+	Logger logger.Logger
+}
+
+type DedupOpt func(o *DedupOpts)
+
+func newDedupOptions(opts ...DedupOpt) DedupOpts {
+	opt := DedupOpts{
+		KeyFunc: messageIDKey,
+	}
+	for _, o := range opts {
+		o(&opt)
+	}
+	if opt.Logger == nil {
+		opt.Logger = logger.New(
+			logger.WithNamespace("kafka"),
+			logger.WithConsole(true),
+			logger.WithDisableDisk(true),
+			logger.WithFields(map[string]interface{}{
+				"event": consumerEvent,
+			}),
+		)
+	}
+	return opt
+}
+
+// DedupKeyFunc sets the function returning the key identifying a message.
+func DedupKeyFunc(fn func(message *ConsumerMessage) string) DedupOpt {
+	return func(o *DedupOpts) {
+		o.KeyFunc = fn
+	}
+}
+
+// DedupMetrics counts the skipped duplicates.
+func DedupMetrics(metrics *Metrics) DedupOpt {
+	return func(o *DedupOpts) {
+		o.Metrics = metrics
+	}
+}
+
+func DedupLogger(logger logger.Logger) DedupOpt {
+	return func(o *DedupOpts) {
+		o.Logger = logger
+	}
+}
+
+// messageIDKey scopes the MessageIDHeader by topic, a message forwarded to
+// another topic keeps its id.
+func messageIDKey(message *ConsumerMessage) string {
+	id := message.Header(MessageIDHeader)
+	if id == "" {
+		return ""
+	}
+	return message.Topic + "/" + id
+}
+
+// Dedup skips the messages already processed successfully according to
+// store and marks them as consumed. The messages whose handler fails are not
+// recorded, so that they are processed again when redelivered. A store
+// failure lets the message through.
+func Dedup(store DedupStore, opts ...DedupOpt) Middleware {
+	options := newDedupOptions(opts...)
+	return func(next Handler) Handler {
+		return func(ctx context.Context, session sarama.ConsumerGroupSession, message *ConsumerMessage) error {
+			key := options.KeyFunc(message)
+			if key == "" {
+				return next(ctx, session, message)
+			}
+
+			seen, err := store.Seen(ctx, key)
+			if err != nil {
+				options.Logger.Warnf("dedup lookup %s failed: %v", key, err)
+			}
+			if seen {
+				options.Logger.Debugf("skip duplicate %s at %s/%d/%d", key, message.Topic, message.Partition, message.Offset)
+				options.Metrics.observeDuplicate(message)
+				session.MarkMessage(encodedConsumerMessage(message), "")
+				return nil
+			}
+
+			if err := next(ctx, session, message); err != nil {
+				return err
+			}
+			if err := store.Mark(ctx, key); err != nil {
+				options.Logger.Warnf("dedup record %s failed: %v", key, err)
+			}
+			return nil
+		}
+	}
+}
+
+// MemoryDedupStore is an in-memory DedupStore keeping the most recently
+// processed keys for a limited time.
+type MemoryDedupStore struct {
+	size int
+	ttl  time.Duration
+
+	mutex sync.Mutex
+	order *list.List // front is the most recent
+	keys  map[string]*list.Element
+}
+
+type dedupEntry struct {
+	key     string
+	expires time.Time
+}
+
+// NewMemoryDedupStore keeps up to size keys, each for ttl, zero ttl keeps
+// them until evicted.
+func NewMemoryDedupStore(size int, ttl time.Duration) *MemoryDedupStore {
+	return &MemoryDedupStore{
+		size:  size,
+		ttl:   ttl,
+		order: list.New(),
+		keys:  make(map[string]*list.Element),
+	}
+}
+
+func (s *MemoryDedupStore) Seen(ctx context.Context, key string) (bool, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	element, ok := s.keys[key]
+	if !ok {
+		return false, nil
+	}
+	if entry := element.Value.(*dedupEntry); s.ttl > 0 && time.Now().After(entry.expires) {
+		s.order.Remove(element)
+		delete(s.keys, key)
+		return false, nil
+	}
+	return true, nil
+}
+
+func (s *MemoryDedupStore) Mark(ctx context.Context, key string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	expires := time.Now().Add(s.ttl)
+	if element, ok := s.keys[key]; ok {
+		element.Value.(*dedupEntry).expires = expires
+		s.order.MoveToFront(element)
+		return nil
+	}
+
+	s.keys[key] = s.order.PushFront(&dedupEntry{key: key, expires: expires})
+	for s.size > 0 && s.order.Len() > s.size {
+		oldest := s.order.Back()
+		s.order.Remove(oldest)
+		delete(s.keys, oldest.Value.(*dedupEntry).key)
+	}
+	return nil
+}
+
+// Len returns the number of keys held, including the expired ones not yet evicted.
+func (s *MemoryDedupStore) Len() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.order.Len()
+}
@@ -0,0 +1,77 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemoryDedupStore(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryDedupStore(2, 50*time.Millisecond)
+
+	_ = store.Mark(ctx, "a")
+	_ = store.Mark(ctx, "b")
+	seen, _ := store.Seen(ctx, "a")
+	assert.True(t, seen)
+
+	// "a" was refreshed, "b" is the least recently marked.
+	_ = store.Mark(ctx, "a")
+	_ = store.Mark(ctx, "c")
+	seen, _ = store.Seen(ctx, "b")
+	assert.False(t, seen)
+	assert.Equal(t, 2, store.Len())
+
+	time.Sleep(60 * time.Millisecond)
+	seen, _ = store.Seen(ctx, "c")
+	assert.False(t, seen)
+	assert.Equal(t, 1, store.Len())
+}
+
+func TestDedup(t *testing.T) {
+	broker := NewMockBroker(1)
+	w := broker.NewWriter()
+	defer w.Close()
+	_ = w.SendMessage(context.Background(), &ProducerMessage{Topic: "t", Value: []byte("a"), MessageID: "1"})
+	_ = w.SendMessage(context.Background(), &ProducerMessage{Topic: "t", Value: []byte("b"), MessageID: "2"})
+	// The producer retried the first message.
+	_ = w.SendMessage(context.Background(), &ProducerMessage{Topic: "t", Value: []byte("a"), MessageID: "1"})
+
+	registry := prometheus.NewRegistry()
+	metrics := NewPrometheusMetrics(registry)
+	dedup := Dedup(NewMemoryDedupStore(100, time.Minute), DedupMetrics(metrics))
+	var handled []string
+	failB := true
+	r := broker.NewReader("t", "g")
+	defer r.Close()
+	_ = r.FetchMessage(context.Background(), dedup(func(ctx context.Context, session sarama.ConsumerGroupSession, message *ConsumerMessage) error {
+		handled = append(handled, string(message.Value))
+		if string(message.Value) == "b" && failB {
+			return errors.New("failed")
+		}
+		return nil
+	}))
+	waitIdle(t, broker)
+	assert.Equal(t, []string{"a", "b"}, handled)
+	// The duplicate is marked as consumed.
+	assert.Equal(t, int64(3), broker.Committed("g", "t", 0))
+
+	// Consumed again by another group sharing the store, only the failed
+	// message is handled.
+	failB = false
+	handled = nil
+	r2 := broker.NewReader("t", "other")
+	defer r2.Close()
+	_ = r2.FetchMessage(context.Background(), dedup(func(ctx context.Context, session sarama.ConsumerGroupSession, message *ConsumerMessage) error {
+		handled = append(handled, string(message.Value))
+		return nil
+	}))
+	waitIdle(t, broker)
+	assert.Equal(t, []string{"b"}, handled)
+	assert.Equal(t, float64(3), gatherValue(t, registry, "kafka_consumer_duplicates_total", map[string]string{"topic": "t"}))
+}
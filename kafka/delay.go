@@ -0,0 +1,301 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/LabKiko/kiko-gokit/logger"
+	"github.com/Shopify/sarama"
+)
+
+const (
+	// DelayDeliverAtHeader is the header carrying the delivery time of a
+	// delayed message, in unix milliseconds.
+	DelayDeliverAtHeader = "delay-deliver-at"
+	// DelayTargetHeader is the header carrying the topic a delayed message
+	// is delivered to.
+	DelayTargetHeader = "delay-target"
+	// DelayParkedAtHeader is the header carrying the time a delayed message
+	// was parked in its bucket topic, in unix milliseconds.
+	DelayParkedAtHeader = "delay-parked-at"
+
+	defaultDelayTopicPrefix = "delay"
+)
+
+var defaultDelayBuckets = []time.Duration{
+	5 * time.Second,
+	30 * time.Second,
+	time.Minute,
+	5 * time.Minute,
+	15 * time.Minute,
+	30 * time.Minute,
+	time.Hour,
+	2 * time.Hour,
+	6 * time.Hour,
+	12 * time.Hour,
+	24 * time.Hour,
+}
+
+type DelayOpts struct {
+	// Buckets are the delays of the bucket topics, a message is parked in
+	// the largest bucket not exceeding its remaining delay and moved to
+	// smaller buckets until due. The smallest bucket bounds the precision.
+	Buckets []time.Duration
+
+	// TopicPrefix prefixes the bucket topics, e.g. "delay.30m", default "delay".
+	TopicPrefix string
+
+	Logger logger.Logger
+}
+
+type DelayOpt func(o *DelayOpts)
+
+func newDelayOptions(opts ...DelayOpt) DelayOpts {
+	opt := DelayOpts{
+		Buckets:     defaultDelayBuckets,
+		TopicPrefix: defaultDelayTopicPrefix,
+	}
+	for _, o := range opts {
+		o(&opt)
+	}
+	opt.Buckets = append([]time.Duration(nil), opt.Buckets...)
+	sort.Slice(opt.Buckets, func(i, j int) bool { return opt.Buckets[i] < opt.Buckets[j] })
+	if opt.Logger == nil {
+		opt.Logger = logger.New(
+			logger.WithNamespace("kafka"),
+			logger.WithConsole(true),
+			logger.WithDisableDisk(true),
+			logger.WithFields(map[string]interface{}{
+				"event": "delay",
+			}),
+		)
+	}
+	return opt
+}
+
+// DelayBuckets sets the delays of the bucket topics.
+func DelayBuckets(buckets ...time.Duration) DelayOpt {
+	return func(o *DelayOpts) {
+		o.Buckets = buckets
+	}
+}
+
+// DelayTopicPrefix sets the prefix of the bucket topics.
+func DelayTopicPrefix(prefix string) DelayOpt {
+	return func(o *DelayOpts) {
+		o.TopicPrefix = prefix
+	}
+}
+
+func DelayLogger(logger logger.Logger) DelayOpt {
+	return func(o *DelayOpts) {
+		o.Logger = logger
+	}
+}
+
+// DelayQueue delivers messages to their topic at a given time. The messages
+// are parked in bucket topics, one per delay, whose readers re-publish them
+// once due. Key, headers and message id are preserved and the re-published
+// message continues the trace of the parked one.
+//
+// The parked messages are only delivered while a DelayQueue is started,
+// in one or more processes sharing the same consumer group.
+type DelayQueue struct {
+	writer Writer
+	opts   DelayOpts
+
+	mutex   sync.Mutex
+	readers []Reader
+	closing chan struct{}
+	closed  bool
+}
+
+// NewDelayQueue creates a DelayQueue parking and delivering the messages with w.
+func NewDelayQueue(w Writer, opts ...DelayOpt) (*DelayQueue, error) {
+	options := newDelayOptions(opts...)
+	if len(options.Buckets) == 0 || options.Buckets[0] <= 0 {
+		return nil, errors.New("kafka: delay buckets must be positive")
+	}
+	return &DelayQueue{
+		writer:  w,
+		opts:    options,
+		closing: make(chan struct{}),
+	}, nil
+}
+
+// Topics returns the bucket topics.
+func (q *DelayQueue) Topics() []string {
+	topics := make([]string, 0, len(q.opts.Buckets))
+	for _, bucket := range q.opts.Buckets {
+		topics = append(topics, q.topic(bucket))
+	}
+	return topics
+}
+
+// SendAt delivers message to its topic at deliverAt, right away if it is
+// not in the future.
+func (q *DelayQueue) SendAt(ctx context.Context, message *ProducerMessage, deliverAt time.Time) error {
+	remaining := time.Until(deliverAt)
+	if remaining <= 0 {
+		return q.writer.SendMessage(ctx, message)
+	}
+
+	parked := &ProducerMessage{
+		Topic:     q.topic(q.bucket(remaining)),
+		Key:       message.Key,
+		Value:     message.Value,
+		MessageID: message.MessageID,
+	}
+	for _, h := range message.Headers {
+		parked.Headers = append(parked.Headers, &RecordHeader{Key: h.Key, Value: h.Value})
+	}
+	parked.SetHeader(DelayTargetHeader, message.Topic)
+	parked.SetHeader(DelayDeliverAtHeader, strconv.FormatInt(deliverAt.UnixMilli(), 10))
+	parked.SetHeader(DelayParkedAtHeader, strconv.FormatInt(time.Now().UnixMilli(), 10))
+	if err := q.writer.SendMessage(ctx, parked); err != nil {
+		return err
+	}
+	message.MessageID = parked.MessageID
+	return nil
+}
+
+// SendAfter delivers message to its topic after delay.
+func (q *DelayQueue) SendAfter(ctx context.Context, message *ProducerMessage, delay time.Duration) error {
+	return q.SendAt(ctx, message, time.Now().Add(delay))
+}
+
+// Start consumes every bucket topic with a reader created by newReader and
+// re-publishes the due messages.
+func (q *DelayQueue) Start(newReader func(topic string) (Reader, error)) error {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	if q.closed {
+		return errors.New("kafka: delay queue closed")
+	}
+	for _, bucket := range q.opts.Buckets {
+		r, err := newReader(q.topic(bucket))
+		if err != nil {
+			return err
+		}
+		q.readers = append(q.readers, r)
+		if err := r.FetchMessage(context.Background(), q.handler(r, bucket)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// StartGroup consumes the bucket topics as members of group.
+func (q *DelayQueue) StartGroup(brokers []string, group string, opts ...ReaderOpt) error {
+	return q.Start(func(topic string) (Reader, error) {
+		return NewReader(brokers, topic, group, opts...)
+	})
+}
+
+// Close stops the readers, the messages not yet due stay parked. The writer
+// is not closed.
+func (q *DelayQueue) Close() error {
+	q.mutex.Lock()
+	if q.closed {
+		q.mutex.Unlock()
+		return nil
+	}
+	q.closed = true
+	readers := q.readers
+	q.mutex.Unlock()
+
+	close(q.closing)
+	var err error
+	for _, r := range readers {
+		if e := r.Close(); e != nil {
+			err = e
+		}
+	}
+	return err
+}
+
+// handler waits until the parked message leaves its bucket, the messages of
+// a partition being parked in order, then delivers it or moves it to a
+// smaller bucket.
+func (q *DelayQueue) handler(r Reader, bucket time.Duration) Handler {
+	return func(ctx context.Context, session sarama.ConsumerGroupSession, message *ConsumerMessage) error {
+		target := message.Header(DelayTargetHeader)
+		deliverAt, err := strconv.ParseInt(message.Header(DelayDeliverAtHeader), 10, 64)
+		if target == "" || err != nil {
+			q.opts.Logger.Errorf("drop delayed message %s/%d/%d without target or delivery time", message.Topic, message.Partition, message.Offset)
+			return r.CommitMessage(ctx, session, message)
+		}
+		parkedAt, err := strconv.ParseInt(message.Header(DelayParkedAtHeader), 10, 64)
+		if err != nil {
+			parkedAt = message.Timestamp.UnixMilli()
+		}
+
+		release := time.UnixMilli(parkedAt).Add(bucket)
+		if at := time.UnixMilli(deliverAt); at.Before(release) {
+			release = at
+		}
+		if wait := time.Until(release); wait > 0 {
+			timer := time.NewTimer(wait)
+			select {
+			case <-timer.C:
+			case <-session.Context().Done():
+				timer.Stop()
+				return session.Context().Err()
+			case <-q.closing:
+				timer.Stop()
+				return errors.New("kafka: delay queue closed")
+			}
+		}
+
+		forward := &ProducerMessage{
+			Topic:     target,
+			Key:       string(message.Key),
+			Value:     message.Value,
+			MessageID: message.Header(MessageIDHeader),
+		}
+		for _, h := range message.Headers {
+			if strings.HasPrefix(string(h.Key), "delay-") {
+				continue
+			}
+			forward.Headers = append(forward.Headers, &RecordHeader{Key: h.Key, Value: h.Value})
+		}
+		if err := q.SendAt(ctx, forward, time.UnixMilli(deliverAt)); err != nil {
+			return err
+		}
+		return r.CommitMessage(ctx, session, message)
+	}
+}
+
+// bucket returns the largest bucket not exceeding remaining.
+func (q *DelayQueue) bucket(remaining time.Duration) time.Duration {
+	bucket := q.opts.Buckets[0]
+	for _, b := range q.opts.Buckets {
+		if b > remaining {
+			break
+		}
+		bucket = b
+	}
+	return bucket
+}
+
+func (q *DelayQueue) topic(bucket time.Duration) string {
+	var name string
+	switch {
+	case bucket%time.Hour == 0:
+		name = fmt.Sprintf("%dh", bucket/time.Hour)
+	case bucket%time.Minute == 0:
+		name = fmt.Sprintf("%dm", bucket/time.Minute)
+	case bucket%time.Second == 0:
+		name = fmt.Sprintf("%ds", bucket/time.Second)
+	default:
+		name = fmt.Sprintf("%dms", bucket/time.Millisecond)
+	}
+	return q.opts.TopicPrefix + "." + name
+}
@@ -0,0 +1,59 @@
+package kafka
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDelayQueue(t *testing.T) {
+	broker := NewMockBroker(1)
+	w := broker.NewWriter()
+	defer w.Close()
+
+	q, err := NewDelayQueue(w, DelayBuckets(100*time.Millisecond, 20*time.Millisecond, time.Second))
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"delay.20ms", "delay.100ms", "delay.1s"}, q.Topics())
+	assert.Nil(t, q.Start(func(topic string) (Reader, error) {
+		return broker.NewReader(topic, "delay"), nil
+	}))
+	defer q.Close()
+
+	message := &ProducerMessage{Topic: "notifications", Key: "user-1", Value: []byte("hello")}
+	message.SetHeader("traceparent", "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01")
+	start := time.Now()
+	assert.Nil(t, q.SendAfter(context.Background(), message, 150*time.Millisecond))
+	assert.NotEmpty(t, message.MessageID)
+	assert.Len(t, broker.Messages("delay.100ms"), 1)
+
+	// Due messages are sent right away.
+	assert.Nil(t, q.SendAt(context.Background(), &ProducerMessage{Topic: "notifications", Value: []byte("now")}, time.Time{}))
+
+	assert.Eventually(t, func() bool { return len(broker.Messages("notifications")) == 2 }, 2*time.Second, 5*time.Millisecond)
+	assert.GreaterOrEqual(t, time.Since(start), 149*time.Millisecond)
+
+	delivered := broker.Messages("notifications")[1]
+	assert.Equal(t, "hello", string(delivered.Value))
+	assert.Equal(t, "user-1", string(delivered.Key))
+	assert.Equal(t, message.MessageID, delivered.Header(MessageIDHeader))
+	assert.Equal(t, "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01", delivered.Header("traceparent"))
+	assert.Empty(t, delivered.Header(DelayTargetHeader))
+	assert.Empty(t, delivered.Header(DelayDeliverAtHeader))
+	// Moved from the 100ms bucket to the 20ms one until due.
+	assert.NotEmpty(t, broker.Messages("delay.20ms"))
+}
+
+func TestDelayQueue_Bucket(t *testing.T) {
+	q, err := NewDelayQueue(nil)
+	assert.Nil(t, err)
+	assert.Equal(t, 5*time.Second, q.bucket(time.Second))
+	assert.Equal(t, 30*time.Minute, q.bucket(45*time.Minute))
+	assert.Equal(t, 24*time.Hour, q.bucket(72*time.Hour))
+	assert.Equal(t, "delay.30m", q.topic(30*time.Minute))
+	assert.Equal(t, "delay.2h", q.topic(2*time.Hour))
+
+	_, err = NewDelayQueue(nil, DelayBuckets())
+	assert.NotNil(t, err)
+}
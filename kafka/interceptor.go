@@ -0,0 +1,144 @@
+package kafka
+
+import (
+	"context"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/LabKiko/kiko-gokit/metadata"
+	"github.com/Shopify/sarama"
+)
+
+// EncryptionHeader marks the messages whose value was sealed by Encrypt.
+const EncryptionHeader = "content-encryption"
+
+const aeadEncryption = "aead"
+
+// SendFunc sends a message, it is the producer counterpart of Handler.
+type SendFunc func(ctx context.Context, message *ProducerMessage) error
+
+// Interceptor wraps the sending of the messages by a Writer, e.g. to add
+// headers, validate or transform the messages. The interceptors run within
+// the producer span, before the message is converted and traced.
+type Interceptor func(SendFunc) SendFunc
+
+// ChainInterceptors composes interceptors, the first one is the outermost.
+func ChainInterceptors(i ...Interceptor) Interceptor {
+	return func(next SendFunc) SendFunc {
+		for j := len(i) - 1; j >= 0; j-- {
+			next = i[j](next)
+		}
+		return next
+	}
+}
+
+// ClientMetadata copies the client metadata of the context, see
+// metadata.NewClientContext, into the headers of the message. Only the keys
+// matching one of prefixes are copied when any is given, the headers already
+// set are kept.
+func ClientMetadata(prefixes ...string) Interceptor {
+	return func(next SendFunc) SendFunc {
+		return func(ctx context.Context, message *ProducerMessage) error {
+			if md, ok := metadata.FromClientContext(ctx); ok {
+				md.Range(func(k, v string) bool {
+					if hasPrefix(k, prefixes) && message.Header(k) == "" {
+						message.SetHeader(k, v)
+					}
+					return true
+				})
+			}
+			return next(ctx, message)
+		}
+	}
+}
+
+// MaxMessageBytes rejects the messages whose key, value and headers exceed
+// max bytes with an error wrapping sarama.ErrMessageSizeTooLarge, before they
+// reach the brokers.
+func MaxMessageBytes(max int) Interceptor {
+	return func(next SendFunc) SendFunc {
+		return func(ctx context.Context, message *ProducerMessage) error {
+			size := len(message.Key) + len(message.Value)
+			for _, h := range message.Headers {
+				size += len(h.Key) + len(h.Value)
+			}
+			if size > max {
+				return fmt.Errorf("kafka: message of %d bytes exceeds %d: %w", size, max, sarama.ErrMessageSizeTooLarge)
+			}
+			return next(ctx, message)
+		}
+	}
+}
+
+// Validate rejects the messages for which validate returns an error, e.g.
+// the ones not matching the schema of their topic.
+func Validate(validate func(ctx context.Context, message *ProducerMessage) error) Interceptor {
+	return func(next SendFunc) SendFunc {
+		return func(ctx context.Context, message *ProducerMessage) error {
+			if err := validate(ctx, message); err != nil {
+				return err
+			}
+			return next(ctx, message)
+		}
+	}
+}
+
+// Encrypt seals the value of the messages with aead, the nonce prefixing the
+// ciphertext, and sets the EncryptionHeader. The message passed to SendMessage
+// is left untouched, so that it can be sent again, while the delivery reports
+// carry the sealed one. Decrypt opens the messages on the reader side.
+func Encrypt(aead cipher.AEAD) Interceptor {
+	return func(next SendFunc) SendFunc {
+		return func(ctx context.Context, message *ProducerMessage) error {
+			if message.Header(EncryptionHeader) != "" {
+				return next(ctx, message)
+			}
+
+			nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(message.Value)+aead.Overhead())
+			if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+				return err
+			}
+			sealed := *message
+			sealed.Value = aead.Seal(nonce, nonce, message.Value, []byte(message.Topic))
+			sealed.Headers = make([]*RecordHeader, 0, len(message.Headers)+1)
+			for _, h := range message.Headers {
+				sealed.Headers = append(sealed.Headers, &RecordHeader{Key: h.Key, Value: h.Value})
+			}
+			sealed.SetHeader(EncryptionHeader, aeadEncryption)
+
+			err := next(ctx, &sealed)
+			message.MessageID = sealed.MessageID
+			message.Partition = sealed.Partition
+			message.Offset = sealed.Offset
+			message.Timestamp = sealed.Timestamp
+			return err
+		}
+	}
+}
+
+// Decrypt opens the values sealed by Encrypt with aead, the other messages
+// are passed through.
+func Decrypt(aead cipher.AEAD) Middleware {
+	return func(next Handler) Handler {
+		return func(ctx context.Context, session sarama.ConsumerGroupSession, message *ConsumerMessage) error {
+			if message.Header(EncryptionHeader) != aeadEncryption {
+				return next(ctx, session, message)
+			}
+			if len(message.Value) < aead.NonceSize() {
+				return errors.New("kafka: encrypted message too short")
+			}
+			nonce, ciphertext := message.Value[:aead.NonceSize()], message.Value[aead.NonceSize():]
+			value, err := aead.Open(nil, nonce, ciphertext, []byte(message.Topic))
+			if err != nil {
+				return fmt.Errorf("kafka: decrypt message: %w", err)
+			}
+
+			opened := *message
+			opened.Value = value
+			return next(ctx, session, &opened)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package kafka
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"errors"
+	"testing"
+
+	"github.com/LabKiko/kiko-gokit/metadata"
+	"github.com/Shopify/sarama"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriterInterceptor(t *testing.T) {
+	broker := NewMockBroker(1)
+	errInvalid := errors.New("invalid")
+	w := broker.NewWriter(WriterInterceptor(
+		ClientMetadata("x-md-"),
+		MaxMessageBytes(256),
+		Validate(func(ctx context.Context, message *ProducerMessage) error {
+			if len(message.Value) == 0 {
+				return errInvalid
+			}
+			return nil
+		}),
+	))
+	defer w.Close()
+
+	ctx := metadata.AppendToClientContext(context.Background(), "x-md-tenant", "acme", "internal", "secret")
+	assert.Nil(t, w.SendMessage(ctx, &ProducerMessage{Topic: "t", Value: []byte("a")}))
+	assert.ErrorIs(t, w.SendMessage(ctx, &ProducerMessage{Topic: "t"}), errInvalid)
+	assert.ErrorIs(t, w.SendMessage(ctx, &ProducerMessage{Topic: "t", Value: make([]byte, 300)}), sarama.ErrMessageSizeTooLarge)
+
+	messages := broker.Messages("t")
+	assert.Len(t, messages, 1)
+	assert.Equal(t, "acme", messages[0].Header("x-md-tenant"))
+	assert.Empty(t, messages[0].Header("internal"))
+}
+
+func TestEncrypt(t *testing.T) {
+	block, _ := aes.NewCipher(make([]byte, 32))
+	aead, _ := cipher.NewGCM(block)
+
+	broker := NewMockBroker(1)
+	w := broker.NewWriter(WriterInterceptor(Encrypt(aead)))
+	defer w.Close()
+	message := &ProducerMessage{Topic: "t", Value: []byte("secret")}
+	assert.Nil(t, w.SendMessage(context.Background(), message))
+	assert.Equal(t, "secret", string(message.Value))
+	assert.Empty(t, message.Header(EncryptionHeader))
+	assert.NotEmpty(t, message.MessageID)
+
+	stored := broker.Messages("t")[0]
+	assert.NotContains(t, string(stored.Value), "secret")
+	assert.Equal(t, message.MessageID, stored.Header(MessageIDHeader))
+
+	values := make(chan string, 1)
+	r := broker.NewReader("t", "g", ReaderMiddleware(Decrypt(aead)))
+	defer r.Close()
+	_ = r.FetchMessage(context.Background(), func(ctx context.Context, session sarama.ConsumerGroupSession, message *ConsumerMessage) error {
+		values <- string(message.Value)
+		return r.CommitMessage(ctx, session, message)
+	})
+	waitIdle(t, broker)
+	assert.Equal(t, "secret", <-values)
+}
@@ -8,20 +8,117 @@
 package kafka
 
 import (
+	"fmt"
+	"strings"
+	"sync"
+
 	"github.com/LabKiko/kiko-gokit/logger"
 	"github.com/Shopify/sarama"
 )
 
-func init() {
-	// https://github.com/Shopify/sarama/issues/959
-	sarama.MaxRequestSize = 1000000
-	stdLog := logger.DefaultLogger.StdLog()
-	sarama.Logger = stdLog
-	sarama.DebugLogger = stdLog
+// InitLogger sets the logger the sarama logs are written to,
+// logger.DefaultLogger when nil.
+//
+// sarama 1.38 only logs through the process-wide sarama.Logger and
+// sarama.DebugLogger, without telling which client a line comes from, so
+// they are written to this single logger rather than to the loggers of the
+// clients.
+func InitLogger(log logger.Logger) {
+	saramaLogs.setLogger(log)
 }
 
-func InitLogger(log logger.Logger) {
-	// stdLog := log.StdLog()
-	// sarama.Logger = stdLog
-	// sarama.DebugLogger = stdLog
+// saramaLogs writes the sarama logs to the logger set by InitLogger, at the
+// info level, and the debug ones at the debug level while a client with
+// Debug set is open.
+var saramaLogs = &saramaRouter{}
+
+type saramaRouter struct {
+	install sync.Once
+
+	mutex sync.Mutex
+	log   logger.Logger
+	// debug counts the open clients with Debug set.
+	debug int
+}
+
+func (r *saramaRouter) setLogger(log logger.Logger) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.log = log
+}
+
+// register enables the sarama debug logs when debug is set, until the
+// returned function is called. The sarama loggers are only replaced once the
+// first client registers, importing the package has no side effect.
+func (r *saramaRouter) register(debug bool) (unregister func()) {
+	r.installLoggers()
+	if !debug {
+		return func() {}
+	}
+
+	r.mutex.Lock()
+	r.debug++
+	r.mutex.Unlock()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			r.mutex.Lock()
+			defer r.mutex.Unlock()
+			r.debug--
+		})
+	}
+}
+
+// installLoggers replaces the sarama loggers, once. sarama reads them
+// without synchronization, they must be replaced before its goroutines run.
+func (r *saramaRouter) installLoggers() {
+	r.install.Do(func() {
+		sarama.Logger = &saramaLogger{router: r}
+		sarama.DebugLogger = &saramaLogger{router: r, debug: true}
+	})
+}
+
+// registerLogs enables the sarama debug logs while the client is open when
+// Debug is set, the returned function unregisters it.
+func (o ClientOpts) registerLogs() (unregister func()) {
+	return saramaLogs.register(o.Debug)
+}
+
+func (r *saramaRouter) write(debug bool, line string) {
+	r.mutex.Lock()
+	log, enabled := r.log, !debug || r.debug > 0
+	r.mutex.Unlock()
+
+	if !enabled {
+		return
+	}
+	if log == nil {
+		log = logger.DefaultLogger
+	}
+	line = strings.TrimRight(line, "\n")
+	if debug {
+		log.Debugf("sarama: %s", line)
+		return
+	}
+	log.Infof("sarama: %s", line)
+}
+
+// saramaLogger is the sarama.StdLogger writing to a saramaRouter, debug
+// tells the sarama.DebugLogger apart.
+type saramaLogger struct {
+	router *saramaRouter
+	debug  bool
+}
+
+func (l *saramaLogger) Print(v ...interface{}) {
+	l.router.write(l.debug, fmt.Sprint(v...))
+}
+
+func (l *saramaLogger) Printf(format string, v ...interface{}) {
+	l.router.write(l.debug, fmt.Sprintf(format, v...))
+}
+
+func (l *saramaLogger) Println(v ...interface{}) {
+	l.router.write(l.debug, fmt.Sprintln(v...))
 }
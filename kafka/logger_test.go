@@ -0,0 +1,71 @@
+package kafka
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/LabKiko/kiko-gokit/logger"
+	"github.com/Shopify/sarama"
+	"github.com/stretchr/testify/assert"
+)
+
+func init() {
+	// The mock brokers log from their own goroutines, started before the
+	// clients register.
+	saramaLogs.installLoggers()
+}
+
+// recordingLogger records the info, warn and debug lines, the other methods panic.
+type recordingLogger struct {
+	logger.Logger
+
+	mutex sync.Mutex
+	lines []string
+}
+
+func (l *recordingLogger) Infof(template string, args ...interface{}) {
+	l.record("info " + fmt.Sprintf(template, args...))
+}
+
+func (l *recordingLogger) Warnf(template string, args ...interface{}) {
+	l.record("warn " + fmt.Sprintf(template, args...))
+}
+
+func (l *recordingLogger) Debugf(template string, args ...interface{}) {
+	l.record("debug " + fmt.Sprintf(template, args...))
+}
+
+func (l *recordingLogger) record(line string) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.lines = append(l.lines, line)
+}
+
+func TestSaramaLogs(t *testing.T) {
+	log := &recordingLogger{}
+	InitLogger(log)
+	defer InitLogger(nil)
+
+	unregisterPlain := ClientOpts{}.registerLogs()
+	sarama.Logger.Printf("client/metadata fetching metadata from %s\n", "broker-1")
+	sarama.Logger.Println("Failed to connect to broker-1: connection refused")
+	// The debug lines are dropped while no client has debug enabled.
+	sarama.DebugLogger.Println("sending request")
+
+	unregisterDebug := ClientOpts{Debug: true}.registerLogs()
+	sarama.DebugLogger.Print("received response")
+	unregisterDebug()
+	unregisterDebug()
+	sarama.DebugLogger.Print("dropped")
+	unregisterPlain()
+
+	log.mutex.Lock()
+	defer log.mutex.Unlock()
+	// Other tests' mock brokers may still be logging.
+	assert.Contains(t, log.lines, "info sarama: client/metadata fetching metadata from broker-1")
+	assert.Contains(t, log.lines, "info sarama: Failed to connect to broker-1: connection refused")
+	assert.Contains(t, log.lines, "debug sarama: received response")
+	assert.NotContains(t, log.lines, "debug sarama: sending request")
+	assert.NotContains(t, log.lines, "debug sarama: dropped")
+}
@@ -8,12 +8,16 @@
 package kafka
 
 import (
+	"strings"
 	"time"
 
 	"github.com/Shopify/sarama"
 	uuid "github.com/satori/go.uuid"
 )
 
+// MessageIDHeader is the record header carrying ProducerMessage.MessageID.
+const MessageIDHeader = "message-id"
+
 // RecordHeader stores key and value for a record header
 type RecordHeader struct {
 	Key   []byte
@@ -32,6 +36,16 @@ type ConsumerMessage struct {
 	BlockTimestamp time.Time       // only set if kafka is version 0.10+, outer (compressed) block timestamp
 }
 
+// Header returns the value of the first header named key, or "" if absent.
+func (m *ConsumerMessage) Header(key string) string {
+	for _, h := range m.Headers {
+		if string(h.Key) == key {
+			return string(h.Value)
+		}
+	}
+	return ""
+}
+
 func decodeConsumerMessage(message *sarama.ConsumerMessage) *ConsumerMessage {
 	headers := make([]*RecordHeader, len(message.Headers))
 	for i, h := range message.Headers {
@@ -80,6 +94,10 @@ type ProducerMessage struct {
 	// StringEncoder and ByteEncoder.
 	Value []byte
 
+	// The headers are key-value pairs that are transparently passed
+	// by Kafka between producers and consumers.
+	Headers []*RecordHeader
+
 	// This field is used to hold arbitrary data you wish to include so it
 	// will be available when receiving on the Successes and Errors channels.
 	// Sarama completely ignores this field and is only to be used for
@@ -94,6 +112,7 @@ type ProducerMessage struct {
 	Offset int64
 	// Partition is the partition that the message was sent to. This is only
 	// guaranteed to be defined if the message was successfully delivered.
+	// With the ManualPartitioner it selects the partition to send to.
 	Partition int32
 	// Timestamp is the timestamp assigned to the message by the broker. This
 	// is only guaranteed to be defined if the message was successfully
@@ -101,10 +120,32 @@ type ProducerMessage struct {
 	// least version 0.10.0.
 	Timestamp time.Time
 
-	// MessageID
+	// MessageID uniquely identifies the message, it is generated when empty
+	// and sent in the MessageIDHeader header.
 	MessageID string
 }
 
+// SetHeader sets the header named key, replacing any existing value.
+func (m *ProducerMessage) SetHeader(key, value string) {
+	for _, h := range m.Headers {
+		if string(h.Key) == key {
+			h.Value = []byte(value)
+			return
+		}
+	}
+	m.Headers = append(m.Headers, &RecordHeader{Key: []byte(key), Value: []byte(value)})
+}
+
+// Header returns the value of the first header named key, or "" if absent.
+func (m *ProducerMessage) Header(key string) string {
+	for _, h := range m.Headers {
+		if string(h.Key) == key {
+			return string(h.Value)
+		}
+	}
+	return ""
+}
+
 // ProducerError is the type of error generated when the producer fails to deliver a message.
 // It contains the original ProducerMessage as well as the actual error value.
 type ProducerError struct {
@@ -113,17 +154,63 @@ type ProducerError struct {
 }
 
 func decodeProducerMessage(message *sarama.ProducerMessage) *ProducerMessage {
-	key, _ := message.Key.Encode()
-	value, _ := message.Value.Encode()
+	var key, value []byte
+	if message.Key != nil {
+		key, _ = message.Key.Encode()
+	}
+	if message.Value != nil {
+		value, _ = message.Value.Encode()
+	}
+	headers := make([]*RecordHeader, 0, len(message.Headers))
+	var messageID string
+	for _, h := range message.Headers {
+		if string(h.Key) == MessageIDHeader {
+			messageID = string(h.Value)
+			continue
+		}
+		headers = append(headers, &RecordHeader{Key: h.Key, Value: h.Value})
+	}
 	return &ProducerMessage{
 		Topic:     message.Topic,
 		Key:       string(key),
 		Value:     value,
+		Headers:   headers,
 		Metadata:  message.Metadata,
 		Offset:    message.Offset,
 		Partition: message.Partition,
 		Timestamp: message.Timestamp,
+		MessageID: messageID,
+	}
+}
+
+// pendingMessage is the Metadata of the sarama messages in flight.
+type pendingMessage struct {
+	message *ProducerMessage
+	sentAt  time.Time
+}
+
+// producerMessageFromReport converts a delivery report of sarama back into the
+// ProducerMessage that was sent, keeping its MessageID and Metadata, and
+// returns the time it was sent at.
+func producerMessageFromReport(message *sarama.ProducerMessage) (*ProducerMessage, time.Time) {
+	pending, ok := message.Metadata.(*pendingMessage)
+	if !ok {
+		return decodeProducerMessage(message), message.Timestamp
+	}
+
+	m := *pending.message
+	m.Offset = message.Offset
+	m.Partition = message.Partition
+	m.Timestamp = message.Timestamp
+	return &m, pending.sentAt
+}
+
+func headersToMap(headers []sarama.RecordHeader) map[string]string {
+	m := make(map[string]string, len(headers))
+	for _, v := range headers {
+		m[strings.ToLower(string(v.Key))] = string(v.Value)
 	}
+	return m
 }
 
 func encodedProducerMessage(message *ProducerMessage) *sarama.ProducerMessage {
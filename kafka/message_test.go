@@ -0,0 +1,20 @@
+package kafka
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestToProducerMessage(t *testing.T) {
+	msg := ToProducerMessage(&ProducerMessage{
+		Topic:     "test",
+		Value:     []byte("value"),
+		Partition: 0,
+		Headers:   []*RecordHeader{{Key: []byte("content-type"), Value: []byte("json")}},
+	})
+	assert.Equal(t, int32(0), msg.Partition)
+	headers := headersToMap(msg.Headers)
+	assert.Equal(t, "json", headers["content-type"])
+	assert.NotEmpty(t, headers[MessageIDHeader])
+}
@@ -0,0 +1,196 @@
+package kafka
+
+import (
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/LabKiko/kiko-gokit/metrics"
+	prom "github.com/LabKiko/kiko-gokit/metrics/prometheus"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const metricsNamespace = "kafka"
+
+// Metrics instruments the readers and writers, any nil field is skipped.
+//
+// The producer metrics are labelled by topic, the consumer metrics by topic
+// except Lag which is labelled by topic and partition.
+type Metrics struct {
+	// Sent counts the messages delivered to the brokers.
+	Sent metrics.Counter
+	// Failed counts the messages that could not be delivered.
+	Failed metrics.Counter
+	// SentBytes counts the value bytes of the delivered messages.
+	SentBytes metrics.Counter
+	// SendLatency observes the seconds between the send of a message and
+	// its delivery report.
+	SendLatency metrics.Observer
+
+	// Handled counts the messages passed to the handler.
+	Handled metrics.Counter
+	// HandleErrors counts the messages for which the handler failed.
+	HandleErrors metrics.Counter
+	// ReceivedBytes counts the value bytes of the consumed messages.
+	ReceivedBytes metrics.Counter
+	// HandleDuration observes the seconds spent in the handler.
+	HandleDuration metrics.Observer
+	// Lag is the number of messages between the high-water mark of the
+	// partition and the last consumed offset.
+	Lag metrics.Gauge
+	// Duplicates counts the messages skipped by Dedup.
+	Duplicates metrics.Counter
+}
+
+var (
+	defaultMetrics     *Metrics
+	defaultMetricsOnce sync.Once
+)
+
+// PrometheusMetrics returns the Metrics registered on the default Prometheus
+// registry, it is safe to share between readers and writers.
+func PrometheusMetrics() *Metrics {
+	defaultMetricsOnce.Do(func() {
+		defaultMetrics = NewPrometheusMetrics(prometheus.DefaultRegisterer)
+	})
+	return defaultMetrics
+}
+
+// NewPrometheusMetrics creates the Metrics and registers them on registerer.
+func NewPrometheusMetrics(registerer prometheus.Registerer) *Metrics {
+	topic := []string{"topic"}
+	sent := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Subsystem: "producer",
+		Name:      "messages_sent_total",
+		Help:      "Number of messages delivered to the brokers.",
+	}, topic)
+	failed := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Subsystem: "producer",
+		Name:      "messages_failed_total",
+		Help:      "Number of messages that could not be delivered.",
+	}, topic)
+	sentBytes := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Subsystem: "producer",
+		Name:      "bytes_total",
+		Help:      "Number of value bytes delivered to the brokers.",
+	}, topic)
+	sendLatency := prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Subsystem: "producer",
+		Name:      "send_duration_seconds",
+		Help:      "Seconds between the send of a message and its delivery report.",
+		Buckets:   prometheus.DefBuckets,
+	}, topic)
+	handled := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Subsystem: "consumer",
+		Name:      "messages_total",
+		Help:      "Number of messages passed to the handler.",
+	}, topic)
+	handleErrors := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Subsystem: "consumer",
+		Name:      "handle_errors_total",
+		Help:      "Number of messages for which the handler failed.",
+	}, topic)
+	receivedBytes := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Subsystem: "consumer",
+		Name:      "bytes_total",
+		Help:      "Number of value bytes consumed.",
+	}, topic)
+	handleDuration := prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Subsystem: "consumer",
+		Name:      "handle_duration_seconds",
+		Help:      "Seconds spent in the handler.",
+		Buckets:   prometheus.DefBuckets,
+	}, topic)
+	lag := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Subsystem: "consumer",
+		Name:      "lag",
+		Help:      "Messages between the partition high-water mark and the last consumed offset.",
+	}, []string{"topic", "partition"})
+	duplicates := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Subsystem: "consumer",
+		Name:      "duplicates_total",
+		Help:      "Number of duplicate messages skipped.",
+	}, topic)
+
+	registerer.MustRegister(sent, failed, sentBytes, sendLatency, handled, handleErrors, receivedBytes, handleDuration, lag, duplicates)
+
+	return &Metrics{
+		Sent:           prom.NewCounter(sent),
+		Failed:         prom.NewCounter(failed),
+		SentBytes:      prom.NewCounter(sentBytes),
+		SendLatency:    prom.NewHistogram(sendLatency),
+		Handled:        prom.NewCounter(handled),
+		HandleErrors:   prom.NewCounter(handleErrors),
+		ReceivedBytes:  prom.NewCounter(receivedBytes),
+		HandleDuration: prom.NewHistogram(handleDuration),
+		Lag:            prom.NewGauge(lag),
+		Duplicates:     prom.NewCounter(duplicates),
+	}
+}
+
+// observeSend records the delivery report of a message.
+func (m *Metrics) observeSend(topic string, size int, latency time.Duration, err error) {
+	if m == nil {
+		return
+	}
+	if err != nil {
+		if m.Failed != nil {
+			m.Failed.With(topic).Inc()
+		}
+		return
+	}
+	if m.Sent != nil {
+		m.Sent.With(topic).Inc()
+	}
+	if m.SentBytes != nil {
+		m.SentBytes.With(topic).Add(float64(size))
+	}
+	if m.SendLatency != nil {
+		m.SendLatency.With(topic).Observe(latency.Seconds())
+	}
+}
+
+// observeHandle records the handling of a consumed message, highWaterMark is
+// the offset of the next message to be produced to the partition.
+func (m *Metrics) observeHandle(message *ConsumerMessage, highWaterMark int64, duration time.Duration, err error) {
+	if m == nil {
+		return
+	}
+	if m.Handled != nil {
+		m.Handled.With(message.Topic).Inc()
+	}
+	if err != nil && m.HandleErrors != nil {
+		m.HandleErrors.With(message.Topic).Inc()
+	}
+	if m.ReceivedBytes != nil {
+		m.ReceivedBytes.With(message.Topic).Add(float64(len(message.Value)))
+	}
+	if m.HandleDuration != nil {
+		m.HandleDuration.With(message.Topic).Observe(duration.Seconds())
+	}
+	if m.Lag != nil && highWaterMark > 0 {
+		lag := highWaterMark - message.Offset - 1
+		if lag < 0 {
+			lag = 0
+		}
+		m.Lag.With(message.Topic, strconv.Itoa(int(message.Partition))).Set(float64(lag))
+	}
+}
+
+// observeDuplicate records a duplicate message skipped by Dedup.
+func (m *Metrics) observeDuplicate(message *ConsumerMessage) {
+	if m == nil || m.Duplicates == nil {
+		return
+	}
+	m.Duplicates.With(message.Topic).Inc()
+}
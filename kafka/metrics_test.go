@@ -0,0 +1,70 @@
+package kafka
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+)
+
+func gatherValue(t *testing.T, registry *prometheus.Registry, name string, labels map[string]string) float64 {
+	families, err := registry.Gather()
+	assert.Nil(t, err)
+	for _, family := range families {
+		if family.GetName() != name {
+			continue
+		}
+	metric:
+		for _, m := range family.GetMetric() {
+			for _, label := range m.GetLabel() {
+				if labels[label.GetName()] != label.GetValue() {
+					continue metric
+				}
+			}
+			switch {
+			case m.GetCounter() != nil:
+				return m.GetCounter().GetValue()
+			case m.GetGauge() != nil:
+				return m.GetGauge().GetValue()
+			case m.GetHistogram() != nil:
+				return float64(m.GetHistogram().GetSampleCount())
+			}
+		}
+	}
+	return -1
+}
+
+func TestMetrics_Writer(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	w, producer := newMockAsyncWriter(t, WriterMetrics(NewPrometheusMetrics(registry)))
+	producer.ExpectInputAndSucceed()
+	producer.ExpectInputAndSucceed()
+	producer.ExpectInputAndFail(context.DeadlineExceeded)
+	for i := 0; i < 3; i++ {
+		assert.Nil(t, w.SendMessage(context.Background(), &ProducerMessage{Topic: "orders", Value: []byte("12345")}))
+	}
+	assert.Nil(t, w.Close())
+
+	topic := map[string]string{"topic": "orders"}
+	assert.Equal(t, float64(2), gatherValue(t, registry, "kafka_producer_messages_sent_total", topic))
+	assert.Equal(t, float64(1), gatherValue(t, registry, "kafka_producer_messages_failed_total", topic))
+	assert.Equal(t, float64(10), gatherValue(t, registry, "kafka_producer_bytes_total", topic))
+	assert.Equal(t, float64(2), gatherValue(t, registry, "kafka_producer_send_duration_seconds", topic))
+}
+
+func TestMetrics_Reader(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	m := NewPrometheusMetrics(registry)
+	m.observeHandle(&ConsumerMessage{Topic: "orders", Partition: 3, Offset: 89, Value: []byte("1")}, 100, time.Millisecond, nil)
+	m.observeHandle(&ConsumerMessage{Topic: "orders", Partition: 3, Offset: 90, Value: []byte("1")}, 100, time.Millisecond, context.Canceled)
+
+	topic := map[string]string{"topic": "orders"}
+	assert.Equal(t, float64(2), gatherValue(t, registry, "kafka_consumer_messages_total", topic))
+	assert.Equal(t, float64(1), gatherValue(t, registry, "kafka_consumer_handle_errors_total", topic))
+	assert.Equal(t, float64(9), gatherValue(t, registry, "kafka_consumer_lag", map[string]string{"topic": "orders", "partition": "3"}))
+
+	var nilMetrics *Metrics
+	nilMetrics.observeSend("orders", 1, time.Second, nil)
+}
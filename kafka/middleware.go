@@ -0,0 +1,121 @@
+package kafka
+
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	"github.com/LabKiko/kiko-gokit/logger"
+	"github.com/LabKiko/kiko-gokit/metadata"
+	"github.com/Shopify/sarama"
+)
+
+// Middleware wraps a Handler, e.g. to recover, log or enrich the context.
+type Middleware func(Handler) Handler
+
+// Chain composes middlewares, the first one is the outermost.
+func Chain(m ...Middleware) Middleware {
+	return func(next Handler) Handler {
+		for i := len(m) - 1; i >= 0; i-- {
+			next = m[i](next)
+		}
+		return next
+	}
+}
+
+// PanicError is returned by the handlers wrapped by Recovery when they panic.
+type PanicError struct {
+	Value interface{}
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("kafka: handler panic: %v\n%s", e.Value, e.Stack)
+}
+
+// Recovery converts a handler panic into a *PanicError, so that the claim
+// goes on consuming instead of crashing the process.
+func Recovery() Middleware {
+	return func(next Handler) Handler {
+		return func(ctx context.Context, session sarama.ConsumerGroupSession, message *ConsumerMessage) (err error) {
+			defer func() {
+				if p := recover(); p != nil {
+					err = &PanicError{Value: p, Stack: debug.Stack()}
+				}
+			}()
+			return next(ctx, session, message)
+		}
+	}
+}
+
+// Timeout bounds the context of each message to timeout. The handler must
+// honour the context, it is not interrupted otherwise.
+func Timeout(timeout time.Duration) Middleware {
+	return func(next Handler) Handler {
+		return func(ctx context.Context, session sarama.ConsumerGroupSession, message *ConsumerMessage) error {
+			ctx, cancel := context.WithTimeout(ctx, timeout)
+			defer cancel()
+			return next(ctx, session, message)
+		}
+	}
+}
+
+// Logging logs every handled message with its position and latency, at the
+// debug level on success and the error level on failure.
+func Logging(log logger.Logger) Middleware {
+	return func(next Handler) Handler {
+		return func(ctx context.Context, session sarama.ConsumerGroupSession, message *ConsumerMessage) error {
+			start := time.Now()
+			err := next(ctx, session, message)
+			fields := log.WithFields(map[string]interface{}{
+				"topic":      message.Topic,
+				"partition":  message.Partition,
+				"offset":     message.Offset,
+				"key":        string(message.Key),
+				"message_id": message.Header(MessageIDHeader),
+				"latency":    time.Since(start).Seconds(),
+			})
+			if err != nil {
+				fields.Errorf("handle message failed: %v", err)
+			} else {
+				fields.Debug("handle message")
+			}
+			return err
+		}
+	}
+}
+
+// ServerMetadata merges the headers of the message into the server metadata
+// of the context, see metadata.FromServerContext. Only the headers matching
+// one of prefixes are copied when any is given.
+func ServerMetadata(prefixes ...string) Middleware {
+	return func(next Handler) Handler {
+		return func(ctx context.Context, session sarama.ConsumerGroupSession, message *ConsumerMessage) error {
+			md := metadata.New()
+			for _, h := range message.Headers {
+				if key := string(h.Key); hasPrefix(key, prefixes) {
+					md.Set(key, string(h.Value))
+				}
+			}
+			if len(md) > 0 {
+				ctx = metadata.MergeToServerContext(ctx, md)
+			}
+			return next(ctx, session, message)
+		}
+	}
+}
+
+func hasPrefix(key string, prefixes []string) bool {
+	if len(prefixes) == 0 {
+		return true
+	}
+	key = strings.ToLower(key)
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(key, strings.ToLower(prefix)) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,78 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/LabKiko/kiko-gokit/metadata"
+	"github.com/Shopify/sarama"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChain(t *testing.T) {
+	var calls []string
+	tag := func(name string) Middleware {
+		return func(next Handler) Handler {
+			return func(ctx context.Context, session sarama.ConsumerGroupSession, message *ConsumerMessage) error {
+				calls = append(calls, name)
+				return next(ctx, session, message)
+			}
+		}
+	}
+	handler := Chain(tag("a"), tag("b"))(func(ctx context.Context, session sarama.ConsumerGroupSession, message *ConsumerMessage) error {
+		calls = append(calls, "handler")
+		return nil
+	})
+	assert.Nil(t, handler(context.Background(), nil, &ConsumerMessage{}))
+	assert.Equal(t, []string{"a", "b", "handler"}, calls)
+}
+
+func TestReaderMiddleware(t *testing.T) {
+	broker := NewMockBroker(1)
+	w := broker.NewWriter()
+	defer w.Close()
+	for _, value := range []string{"panic", "ok"} {
+		message := &ProducerMessage{Topic: "t", Value: []byte(value)}
+		message.SetHeader("x-md-tenant", "acme")
+		message.SetHeader("other", "ignored")
+		_ = w.SendMessage(context.Background(), message)
+	}
+
+	var (
+		errs     = make(chan error, 2)
+		tenants  []string
+		deadline bool
+	)
+	record := func(next Handler) Handler {
+		return func(ctx context.Context, session sarama.ConsumerGroupSession, message *ConsumerMessage) error {
+			err := next(ctx, session, message)
+			errs <- err
+			return err
+		}
+	}
+	r := broker.NewReader("t", "g", ReaderMiddleware(record, Recovery(), Timeout(time.Minute), ServerMetadata("x-md-")))
+	defer r.Close()
+	_ = r.FetchMessage(context.Background(), func(ctx context.Context, session sarama.ConsumerGroupSession, message *ConsumerMessage) error {
+		md, _ := metadata.FromServerContext(ctx)
+		tenants = append(tenants, md.Get("x-md-tenant"))
+		assert.Empty(t, md.Get("other"))
+		_, deadline = ctx.Deadline()
+		if string(message.Value) == "panic" {
+			panic("boom")
+		}
+		return r.CommitMessage(ctx, session, message)
+	})
+	waitIdle(t, broker)
+
+	// The panic is returned as an error and the next message is handled.
+	var panicErr *PanicError
+	assert.True(t, errors.As(<-errs, &panicErr))
+	assert.Equal(t, "boom", panicErr.Value)
+	assert.NotEmpty(t, panicErr.Stack)
+	assert.Nil(t, <-errs)
+	assert.Equal(t, []string{"acme", "acme"}, tenants)
+	assert.True(t, deadline)
+	assert.Equal(t, int64(2), broker.Committed("g", "t", 0))
+}
@@ -7,6 +7,17 @@
 
 package kafka
 
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+
+	"github.com/Shopify/sarama"
+)
+
 var _reader Reader
 
 func InitReader(brokers []string, topic, group string) (Reader, error) {
@@ -14,3 +25,625 @@ func InitReader(brokers []string, topic, group string) (Reader, error) {
 	_reader, err = NewReader(brokers, topic, group, ReaderStartOffset(OffsetNewest))
 	return _reader, err
 }
+
+// errMockTransaction is returned by the transactional methods of the MockBroker producer.
+var errMockTransaction = errors.New("kafka: transactions are not supported by MockBroker")
+
+// MockBroker is an in-memory stand-in for a Kafka cluster, it hands out
+// Writer and Reader implementations so that produce and consume flows can be
+// unit tested without network.
+//
+// Topics are created on first use with the default number of partitions,
+// consumer groups assign the partitions of a topic to their members in turn
+// and rebalance whenever a member joins or leaves. Offsets marked with
+// CommitMessage are committed immediately, a rebalance resumes every
+// partition from its committed offset.
+//
+// The writers and readers run the same tracing, metrics and callback code as
+// the ones returned by NewWriter and NewReader. The writers always deliver
+// synchronously, so their Errors and Messages channels are never written to.
+type MockBroker struct {
+	mutex      sync.Mutex
+	cond       *sync.Cond
+	partitions int32
+	topics     map[string][][]*sarama.ConsumerMessage
+	groups     map[string]*mockGroup
+	produceErr error
+	members    int
+}
+
+type mockGroup struct {
+	generation int32
+	committed  map[string]map[int32]int64
+	members    []*mockReader
+}
+
+// NewMockBroker creates a MockBroker whose topics default to partitions partitions.
+func NewMockBroker(partitions int32) *MockBroker {
+	if partitions <= 0 {
+		partitions = 1
+	}
+	b := &MockBroker{
+		partitions: partitions,
+		topics:     make(map[string][][]*sarama.ConsumerMessage),
+		groups:     make(map[string]*mockGroup),
+	}
+	b.cond = sync.NewCond(&b.mutex)
+	return b
+}
+
+// CreateTopic creates topic with the given number of partitions, it is a
+// no-op if the topic exists.
+func (b *MockBroker) CreateTopic(topic string, partitions int32) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if _, ok := b.topics[topic]; !ok {
+		b.topics[topic] = make([][]*sarama.ConsumerMessage, partitions)
+	}
+}
+
+func (b *MockBroker) topicLocked(topic string) [][]*sarama.ConsumerMessage {
+	partitions, ok := b.topics[topic]
+	if !ok {
+		partitions = make([][]*sarama.ConsumerMessage, b.partitions)
+		b.topics[topic] = partitions
+	}
+	return partitions
+}
+
+// SetProduceError makes every produce fail with err until it is reset with nil.
+func (b *MockBroker) SetProduceError(err error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.produceErr = err
+}
+
+// Messages returns the messages of topic ordered by partition and offset.
+func (b *MockBroker) Messages(topic string) []*ConsumerMessage {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	messages := make([]*ConsumerMessage, 0)
+	for _, partition := range b.topics[topic] {
+		for _, msg := range partition {
+			messages = append(messages, decodeConsumerMessage(msg))
+		}
+	}
+	return messages
+}
+
+// HighWaterMark returns the offset of the next message produced to the partition.
+func (b *MockBroker) HighWaterMark(topic string, partition int32) int64 {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	partitions := b.topics[topic]
+	if int(partition) >= len(partitions) {
+		return 0
+	}
+	return int64(len(partitions[partition]))
+}
+
+// Committed returns the offset committed by group on the partition, or -1.
+func (b *MockBroker) Committed(group, topic string, partition int32) int64 {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	return b.committedLocked(group, topic, partition)
+}
+
+func (b *MockBroker) committedLocked(group, topic string, partition int32) int64 {
+	g, ok := b.groups[group]
+	if !ok {
+		return -1
+	}
+	offset, ok := g.committed[topic][partition]
+	if !ok {
+		return -1
+	}
+	return offset
+}
+
+func (b *MockBroker) commit(group, topic string, partition int32, offset int64, force bool) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	g := b.groupLocked(group)
+	if g.committed[topic] == nil {
+		g.committed[topic] = make(map[int32]int64)
+	}
+	if current, ok := g.committed[topic][partition]; !ok || force || offset > current {
+		g.committed[topic][partition] = offset
+	}
+	b.cond.Broadcast()
+}
+
+func (b *MockBroker) groupLocked(group string) *mockGroup {
+	g, ok := b.groups[group]
+	if !ok {
+		g = &mockGroup{committed: make(map[string]map[int32]int64)}
+		b.groups[group] = g
+	}
+	return g
+}
+
+// Rebalance forces a rebalance of group.
+func (b *MockBroker) Rebalance(group string) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if g, ok := b.groups[group]; ok {
+		g.generation++
+		b.cond.Broadcast()
+	}
+}
+
+// WaitIdle blocks until every reader with a handler has consumed all the
+// messages of its partitions that are not paused and no handler is running.
+func (b *MockBroker) WaitIdle(ctx context.Context) error {
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			b.mutex.Lock()
+			b.cond.Broadcast()
+			b.mutex.Unlock()
+		case <-done:
+		}
+	}()
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	for !b.idleLocked() {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		b.cond.Wait()
+	}
+	return nil
+}
+
+func (b *MockBroker) idleLocked() bool {
+	for _, g := range b.groups {
+		for _, r := range g.members {
+			if r.busy {
+				return false
+			}
+			if r.closed || r.core.handler == nil {
+				continue
+			}
+			if r.generation != g.generation {
+				return false
+			}
+			for _, c := range r.claims {
+				if !c.paused && c.position < int64(len(b.topics[c.topic][c.partition])) {
+					return false
+				}
+			}
+		}
+	}
+	return true
+}
+
+func (b *MockBroker) produce(msg *sarama.ProducerMessage, partitioner func(topic string) sarama.Partitioner) (int32, int64, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if b.produceErr != nil {
+		return -1, -1, b.produceErr
+	}
+
+	partitions := b.topicLocked(msg.Topic)
+	partition, err := partitioner(msg.Topic).Partition(msg, int32(len(partitions)))
+	if err != nil {
+		return -1, -1, err
+	}
+	if partition < 0 || int(partition) >= len(partitions) {
+		return -1, -1, sarama.ErrInvalidPartition
+	}
+
+	var key, value []byte
+	if msg.Key != nil {
+		if key, err = msg.Key.Encode(); err != nil {
+			return -1, -1, err
+		}
+	}
+	if msg.Value != nil {
+		if value, err = msg.Value.Encode(); err != nil {
+			return -1, -1, err
+		}
+	}
+	headers := make([]*sarama.RecordHeader, 0, len(msg.Headers))
+	for i := range msg.Headers {
+		headers = append(headers, &sarama.RecordHeader{Key: msg.Headers[i].Key, Value: msg.Headers[i].Value})
+	}
+
+	offset := int64(len(partitions[partition]))
+	partitions[partition] = append(partitions[partition], &sarama.ConsumerMessage{
+		Headers:   headers,
+		Timestamp: msg.Timestamp,
+		Key:       key,
+		Value:     value,
+		Topic:     msg.Topic,
+		Partition: partition,
+		Offset:    offset,
+	})
+	msg.Partition, msg.Offset = partition, offset
+	b.cond.Broadcast()
+	return partition, offset, nil
+}
+
+// NewWriter returns a Writer producing to the broker.
+func (b *MockBroker) NewWriter(opts ...WriterOpt) Writer {
+	options := newWriterOptions(nil, opts...)
+	options.Async = false
+
+	w := allocWriter(options)
+	w.syncProducer = &mockProducer{
+		broker:       b,
+		partitioner:  options.Partitioner,
+		partitioners: make(map[string]sarama.Partitioner),
+	}
+	return w
+}
+
+// mockProducer is a sarama.SyncProducer producing to a MockBroker.
+type mockProducer struct {
+	broker       *MockBroker
+	partitioner  Partitioner
+	partitioners map[string]sarama.Partitioner
+}
+
+// partitionerFor is called with the broker mutex held.
+func (p *mockProducer) partitionerFor(topic string) sarama.Partitioner {
+	partitioner, ok := p.partitioners[topic]
+	if !ok {
+		partitioner = p.partitioner.constructor()(topic)
+		p.partitioners[topic] = partitioner
+	}
+	return partitioner
+}
+
+func (p *mockProducer) SendMessage(msg *sarama.ProducerMessage) (int32, int64, error) {
+	return p.broker.produce(msg, p.partitionerFor)
+}
+
+func (p *mockProducer) SendMessages(msgs []*sarama.ProducerMessage) error {
+	for _, msg := range msgs {
+		if _, _, err := p.SendMessage(msg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *mockProducer) Close() error { return nil }
+
+func (p *mockProducer) TxnStatus() sarama.ProducerTxnStatusFlag { return sarama.ProducerTxnFlagReady }
+
+func (p *mockProducer) IsTransactional() bool { return false }
+
+func (p *mockProducer) BeginTxn() error { return errMockTransaction }
+
+func (p *mockProducer) CommitTxn() error { return errMockTransaction }
+
+func (p *mockProducer) AbortTxn() error { return errMockTransaction }
+
+func (p *mockProducer) AddOffsetsToTxn(map[string][]*sarama.PartitionOffsetMetadata, string) error {
+	return errMockTransaction
+}
+
+func (p *mockProducer) AddMessageToTxn(*sarama.ConsumerMessage, string, *string) error {
+	return errMockTransaction
+}
+
+// NewReader returns a Reader consuming topic as a member of group, joining
+// the group triggers a rebalance.
+func (b *MockBroker) NewReader(topic, group string, opts ...ReaderOpt) Reader {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.members++
+	options := newReaderOptions(nil, topic, group, opts...)
+	r := &mockReader{
+		broker: b,
+		core: &reader{
+			opts:  options,
+			close: make(chan bool),
+			flow:  newFlowControl(options.Backpressure, options.Logger),
+		},
+		id:    fmt.Sprintf("%s-%d", group, b.members),
+		topic: topic,
+		group: group,
+		done:  make(chan struct{}),
+	}
+	r.core.flow.setPauser(mockPauser{r})
+	b.topicLocked(topic)
+	g := b.groupLocked(group)
+	g.members = append(g.members, r)
+	g.generation++
+	b.cond.Broadcast()
+
+	go r.run()
+	return r
+}
+
+type mockReader struct {
+	broker *MockBroker
+	core   *reader
+	id     string
+	topic  string
+	group  string
+
+	// guarded by the broker mutex
+	closed     bool
+	busy       bool
+	generation int32
+	session    *mockSession
+	claims     []*mockClaim
+	cursor     int
+
+	done chan struct{}
+}
+
+func (r *mockReader) FetchMessage(ctx context.Context, handler Handler) error {
+	if r.core.isClosed() {
+		return io.EOF
+	}
+
+	r.broker.mutex.Lock()
+	defer r.broker.mutex.Unlock()
+
+	r.core.handler = Chain(r.core.opts.Middlewares...)(handler)
+	r.broker.cond.Broadcast()
+	return nil
+}
+
+func (r *mockReader) Pause(topic string, partitions ...int32) {
+	r.core.Pause(topic, partitions...)
+}
+
+func (r *mockReader) Resume(topic string, partitions ...int32) {
+	r.core.Resume(topic, partitions...)
+}
+
+func (r *mockReader) CommitMessage(ctx context.Context, session sarama.ConsumerGroupSession, message *ConsumerMessage) error {
+	return r.core.CommitMessage(ctx, session, message)
+}
+
+// Close stops consuming, waits up to DrainTimeout for the running handler to
+// return and then leaves the group, which triggers a rebalance.
+func (r *mockReader) Close() error {
+	if !r.core.markClosed() {
+		return nil
+	}
+
+	b := r.broker
+	b.mutex.Lock()
+	r.closed = true
+	b.cond.Broadcast()
+	b.mutex.Unlock()
+
+	if r.core.opts.DrainTimeout > 0 && !drain(r.done, r.core.opts.DrainTimeout) {
+		r.core.opts.Logger.Warnf("drain timeout after %s, closing with in-flight handlers", r.core.opts.DrainTimeout)
+	}
+	r.core.flow.stop()
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	g := b.groups[r.group]
+	for i, m := range g.members {
+		if m == r {
+			g.members = append(g.members[:i], g.members[i+1:]...)
+			break
+		}
+	}
+	g.generation++
+	b.cond.Broadcast()
+	return nil
+}
+
+func (r *mockReader) run() {
+	defer close(r.done)
+
+	b := r.broker
+	b.mutex.Lock()
+	for !r.closed {
+		g := b.groups[r.group]
+		if r.core.handler == nil {
+			b.cond.Wait()
+			continue
+		}
+
+		if r.generation != g.generation {
+			previous := r.session
+			r.newSessionLocked(g)
+			session := r.session
+			b.mutex.Unlock()
+			if previous != nil {
+				previous.cancel()
+				_ = r.core.Cleanup(previous)
+			}
+			_ = r.core.Setup(session)
+			for _, partition := range session.claims[r.topic] {
+				r.core.flow.claim(r.topic, partition)
+			}
+			b.mutex.Lock()
+			b.cond.Broadcast()
+			continue
+		}
+
+		claim, msg := r.nextLocked()
+		if msg == nil {
+			b.cond.Wait()
+			continue
+		}
+		claim.position = msg.Offset + 1
+		r.busy = true
+		session := r.session
+		b.mutex.Unlock()
+
+		if err := r.core.Handler(msg, session, claim); err != nil {
+			r.core.opts.Logger.Error(err)
+		}
+
+		b.mutex.Lock()
+		r.busy = false
+		b.cond.Broadcast()
+	}
+	session := r.session
+	b.mutex.Unlock()
+
+	if session != nil {
+		session.cancel()
+		_ = r.core.Cleanup(session)
+	}
+}
+
+// newSessionLocked assigns the partitions of the topic to the members of the
+// group subscribed to it in turn and resumes them from the committed offsets.
+func (r *mockReader) newSessionLocked(g *mockGroup) {
+	b := r.broker
+	subscribers := make([]string, 0, len(g.members))
+	for _, m := range g.members {
+		if m.topic == r.topic {
+			subscribers = append(subscribers, m.id)
+		}
+	}
+	sort.Strings(subscribers)
+	index := sort.SearchStrings(subscribers, r.id)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	session := &mockSession{
+		broker:     b,
+		group:      r.group,
+		memberID:   r.id,
+		generation: g.generation,
+		claims:     map[string][]int32{r.topic: {}},
+		ctx:        ctx,
+		cancel:     cancel,
+	}
+
+	r.claims = r.claims[:0]
+	r.cursor = 0
+	for partition := range b.topics[r.topic] {
+		if partition%len(subscribers) != index {
+			continue
+		}
+		offset := b.committedLocked(r.group, r.topic, int32(partition))
+		if offset < 0 {
+			offset = 0
+			if r.core.opts.StartOffset == OffsetNewest {
+				offset = int64(len(b.topics[r.topic][partition]))
+			}
+		}
+		session.claims[r.topic] = append(session.claims[r.topic], int32(partition))
+		r.claims = append(r.claims, &mockClaim{
+			broker:    b,
+			topic:     r.topic,
+			partition: int32(partition),
+			initial:   offset,
+			position:  offset,
+		})
+	}
+
+	r.session = session
+	r.generation = g.generation
+}
+
+// nextLocked returns the next message to consume, walking the claims in turn.
+func (r *mockReader) nextLocked() (*mockClaim, *sarama.ConsumerMessage) {
+	for i := range r.claims {
+		c := r.claims[(r.cursor+i)%len(r.claims)]
+		messages := r.broker.topics[c.topic][c.partition]
+		if !c.paused && c.position < int64(len(messages)) {
+			r.cursor = (r.cursor + i + 1) % len(r.claims)
+			return c, messages[c.position]
+		}
+	}
+	return nil, nil
+}
+
+// mockPauser pauses the claims of a mockReader.
+type mockPauser struct {
+	reader *mockReader
+}
+
+func (p mockPauser) Pause(partitions map[string][]int32) {
+	p.set(partitions, true)
+}
+
+func (p mockPauser) Resume(partitions map[string][]int32) {
+	p.set(partitions, false)
+}
+
+func (p mockPauser) set(partitions map[string][]int32, paused bool) {
+	b := p.reader.broker
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	for _, c := range p.reader.claims {
+		for _, partition := range partitions[c.topic] {
+			if partition == c.partition {
+				c.paused = paused
+			}
+		}
+	}
+	b.cond.Broadcast()
+}
+
+// mockSession is a sarama.ConsumerGroupSession of a MockBroker group.
+type mockSession struct {
+	broker     *MockBroker
+	group      string
+	memberID   string
+	generation int32
+	claims     map[string][]int32
+	ctx        context.Context
+	cancel     context.CancelFunc
+}
+
+func (s *mockSession) Claims() map[string][]int32 { return s.claims }
+
+func (s *mockSession) MemberID() string { return s.memberID }
+
+func (s *mockSession) GenerationID() int32 { return s.generation }
+
+func (s *mockSession) MarkOffset(topic string, partition int32, offset int64, metadata string) {
+	s.broker.commit(s.group, topic, partition, offset, false)
+}
+
+func (s *mockSession) Commit() {}
+
+func (s *mockSession) ResetOffset(topic string, partition int32, offset int64, metadata string) {
+	s.broker.commit(s.group, topic, partition, offset, true)
+}
+
+func (s *mockSession) MarkMessage(msg *sarama.ConsumerMessage, metadata string) {
+	s.MarkOffset(msg.Topic, msg.Partition, msg.Offset+1, metadata)
+}
+
+func (s *mockSession) Context() context.Context { return s.ctx }
+
+// mockClaim is a sarama.ConsumerGroupClaim of a MockBroker partition.
+type mockClaim struct {
+	broker    *MockBroker
+	topic     string
+	partition int32
+	initial   int64
+	position  int64
+	paused    bool
+}
+
+func (c *mockClaim) Topic() string { return c.topic }
+
+func (c *mockClaim) Partition() int32 { return c.partition }
+
+func (c *mockClaim) InitialOffset() int64 { return c.initial }
+
+func (c *mockClaim) HighWaterMarkOffset() int64 { return c.broker.HighWaterMark(c.topic, c.partition) }
+
+func (c *mockClaim) Messages() <-chan *sarama.ConsumerMessage { return nil }
@@ -0,0 +1,231 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+)
+
+func waitIdle(t *testing.T, broker *MockBroker) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := broker.WaitIdle(ctx); err != nil {
+		t.Fatalf("WaitIdle() error = %v", err)
+	}
+}
+
+func TestMockBroker_ProduceConsume(t *testing.T) {
+	broker := NewMockBroker(3)
+	w := broker.NewWriter(WriterPartitioner(HashPartitioner))
+	defer w.Close()
+	r := broker.NewReader("orders", "billing", ReaderStartOffset(OffsetOldest))
+	defer r.Close()
+
+	for i := 0; i < 10; i++ {
+		message := &ProducerMessage{Topic: "orders", Key: fmt.Sprintf("key-%d", i%2), Value: []byte(fmt.Sprint(i))}
+		message.SetHeader("tenant", "acme")
+		if err := w.SendMessage(context.Background(), message); err != nil {
+			t.Fatalf("SendMessage() error = %v", err)
+		}
+		if message.Offset < 0 {
+			t.Fatalf("SendMessage() offset = %d", message.Offset)
+		}
+	}
+
+	var (
+		mutex    sync.Mutex
+		received []*ConsumerMessage
+	)
+	err := r.FetchMessage(context.Background(), func(ctx context.Context, session sarama.ConsumerGroupSession, message *ConsumerMessage) error {
+		mutex.Lock()
+		received = append(received, message)
+		mutex.Unlock()
+		return r.CommitMessage(ctx, session, message)
+	})
+	if err != nil {
+		t.Fatalf("FetchMessage() error = %v", err)
+	}
+	waitIdle(t, broker)
+
+	if len(received) != 10 {
+		t.Fatalf("received %d messages, want 10", len(received))
+	}
+	partitions := make(map[string]int32)
+	for _, message := range received {
+		if message.Header("tenant") != "acme" || message.Header(MessageIDHeader) == "" {
+			t.Errorf("message headers = %v", message.Headers)
+		}
+		if p, ok := partitions[string(message.Key)]; ok && p != message.Partition {
+			t.Errorf("key %s consumed from partitions %d and %d", message.Key, p, message.Partition)
+		}
+		partitions[string(message.Key)] = message.Partition
+	}
+	for partition := int32(0); partition < 3; partition++ {
+		if got, want := broker.Committed("billing", "orders", partition), broker.HighWaterMark("orders", partition); got != want && !(got == -1 && want == 0) {
+			t.Errorf("Committed(%d) = %d, want %d", partition, got, want)
+		}
+	}
+}
+
+func TestMockBroker_ConsumerGroup(t *testing.T) {
+	broker := NewMockBroker(4)
+	w := broker.NewWriter(WriterPartitioner(ManualPartitioner))
+	defer w.Close()
+
+	var (
+		mutex    sync.Mutex
+		consumed = make(map[int32]map[int64]int)
+		members  = make(map[int32]string)
+	)
+	handler := func(ctx context.Context, session sarama.ConsumerGroupSession, message *ConsumerMessage) error {
+		mutex.Lock()
+		if consumed[message.Partition] == nil {
+			consumed[message.Partition] = make(map[int64]int)
+		}
+		consumed[message.Partition][message.Offset]++
+		members[message.Partition] = session.MemberID()
+		mutex.Unlock()
+		return nil
+	}
+
+	first := broker.NewReader("events", "workers", ReaderStartOffset(OffsetOldest))
+	second := broker.NewReader("events", "workers", ReaderStartOffset(OffsetOldest))
+	_ = first.FetchMessage(context.Background(), func(ctx context.Context, session sarama.ConsumerGroupSession, message *ConsumerMessage) error {
+		_ = handler(ctx, session, message)
+		return first.CommitMessage(ctx, session, message)
+	})
+	_ = second.FetchMessage(context.Background(), func(ctx context.Context, session sarama.ConsumerGroupSession, message *ConsumerMessage) error {
+		_ = handler(ctx, session, message)
+		return second.CommitMessage(ctx, session, message)
+	})
+
+	for partition := int32(0); partition < 4; partition++ {
+		if err := w.SendMessage(context.Background(), &ProducerMessage{Topic: "events", Partition: partition, Value: []byte("a")}); err != nil {
+			t.Fatalf("SendMessage() error = %v", err)
+		}
+	}
+	waitIdle(t, broker)
+
+	mutex.Lock()
+	if len(members) != 4 {
+		t.Fatalf("consumed partitions = %v", members)
+	}
+	if members[0] != members[2] || members[1] != members[3] || members[0] == members[1] {
+		t.Errorf("partition assignment = %v", members)
+	}
+	mutex.Unlock()
+
+	// The second member leaves, the first one takes over every partition
+	// from the committed offsets.
+	if err := second.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	if err := second.FetchMessage(context.Background(), handler); err == nil {
+		t.Errorf("FetchMessage() after Close() error = nil")
+	}
+	for partition := int32(0); partition < 4; partition++ {
+		_ = w.SendMessage(context.Background(), &ProducerMessage{Topic: "events", Partition: partition, Value: []byte("b")})
+	}
+	waitIdle(t, broker)
+	_ = first.Close()
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	for partition := int32(0); partition < 4; partition++ {
+		for offset := int64(0); offset < 2; offset++ {
+			if n := consumed[partition][offset]; n != 1 {
+				t.Errorf("partition %d offset %d consumed %d times", partition, offset, n)
+			}
+		}
+	}
+}
+
+func TestMockBroker_Redelivery(t *testing.T) {
+	broker := NewMockBroker(1)
+	w := broker.NewWriter()
+	defer w.Close()
+	for i := 0; i < 3; i++ {
+		_ = w.SendMessage(context.Background(), &ProducerMessage{Topic: "jobs", Value: []byte(fmt.Sprint(i))})
+	}
+
+	// Only the first message is committed, a new member of the group
+	// resumes after it.
+	r := broker.NewReader("jobs", "runner", ReaderStartOffset(OffsetOldest))
+	_ = r.FetchMessage(context.Background(), func(ctx context.Context, session sarama.ConsumerGroupSession, message *ConsumerMessage) error {
+		if message.Offset == 0 {
+			return r.CommitMessage(ctx, session, message)
+		}
+		return errors.New("failed")
+	})
+	waitIdle(t, broker)
+	_ = r.Close()
+	if got := broker.Committed("runner", "jobs", 0); got != 1 {
+		t.Fatalf("Committed() = %d, want 1", got)
+	}
+
+	var offsets []int64
+	r = broker.NewReader("jobs", "runner", ReaderStartOffset(OffsetOldest))
+	defer r.Close()
+	_ = r.FetchMessage(context.Background(), func(ctx context.Context, session sarama.ConsumerGroupSession, message *ConsumerMessage) error {
+		offsets = append(offsets, message.Offset)
+		return nil
+	})
+	waitIdle(t, broker)
+	if fmt.Sprint(offsets) != "[1 2]" {
+		t.Errorf("redelivered offsets = %v, want [1 2]", offsets)
+	}
+}
+
+func TestMockBroker_StartOffsetNewest(t *testing.T) {
+	broker := NewMockBroker(1)
+	w := broker.NewWriter()
+	defer w.Close()
+	_ = w.SendMessage(context.Background(), &ProducerMessage{Topic: "logs", Value: []byte("old")})
+
+	var values []string
+	r := broker.NewReader("logs", "tail", ReaderStartOffset(OffsetNewest))
+	defer r.Close()
+	_ = r.FetchMessage(context.Background(), func(ctx context.Context, session sarama.ConsumerGroupSession, message *ConsumerMessage) error {
+		values = append(values, string(message.Value))
+		return nil
+	})
+	waitIdle(t, broker)
+	_ = w.SendMessage(context.Background(), &ProducerMessage{Topic: "logs", Value: []byte("new")})
+	waitIdle(t, broker)
+
+	if fmt.Sprint(values) != "[new]" {
+		t.Errorf("consumed = %v, want [new]", values)
+	}
+}
+
+func TestMockBroker_ProduceError(t *testing.T) {
+	broker := NewMockBroker(1)
+	var reported error
+	w := broker.NewWriter(WriterCallback(func(message *ProducerMessage, err error) {
+		reported = err
+	}))
+	defer w.Close()
+
+	broker.SetProduceError(sarama.ErrNotLeaderForPartition)
+	err := w.SendMessage(context.Background(), &ProducerMessage{Topic: "t", Value: []byte("a")})
+	if !errors.Is(err, sarama.ErrNotLeaderForPartition) || !errors.Is(reported, sarama.ErrNotLeaderForPartition) {
+		t.Errorf("SendMessage() error = %v, callback error = %v", err, reported)
+	}
+	if n := len(broker.Messages("t")); n != 0 {
+		t.Errorf("Messages() = %d, want 0", n)
+	}
+
+	broker.SetProduceError(nil)
+	if err := w.SendMessage(context.Background(), &ProducerMessage{Topic: "t", Value: []byte("a")}); err != nil {
+		t.Errorf("SendMessage() error = %v", err)
+	}
+	if n := len(broker.Messages("t")); n != 1 {
+		t.Errorf("Messages() = %d, want 1", n)
+	}
+}
@@ -9,8 +9,10 @@ package kafka
 
 import (
 	"strings"
+	"time"
 
 	"github.com/LabKiko/kiko-gokit/logger"
+	"github.com/Shopify/sarama"
 )
 
 const (
@@ -18,6 +20,9 @@ const (
 	producerEvent              = "producer"
 	defaultProducerServiceName = "go-kafka-producer"
 	defaultConsumerServiceName = "go-kafka-consumer"
+	defaultAdminServiceName    = "go-kafka-admin"
+	defaultDrainTimeout        = 10 * time.Second
+	defaultMaxMessageBytes     = 1000000 // 1M
 )
 
 type StartOffset int
@@ -35,7 +40,43 @@ const (
 	OffsetOldest StartOffset = -2
 )
 
+// RebalanceStrategy is the partition assignment strategy of a consumer group.
+// The cooperative protocol is not supported by sarama, hence not offered.
+type RebalanceStrategy int
+
+const (
+	// RebalanceRoundRobin assigns the partitions to the members in turn.
+	RebalanceRoundRobin RebalanceStrategy = iota
+	// RebalanceRange assigns each member a contiguous range of partitions.
+	RebalanceRange
+	// RebalanceSticky keeps the previous assignment as much as possible.
+	RebalanceSticky
+)
+
+func (s RebalanceStrategy) balanceStrategy() sarama.BalanceStrategy {
+	switch s {
+	case RebalanceRange:
+		return sarama.BalanceStrategyRange
+	case RebalanceSticky:
+		return sarama.BalanceStrategySticky
+	default:
+		return sarama.BalanceStrategyRoundRobin
+	}
+}
+
+type IsolationLevel int8
+
+const (
+	// ReadUncommitted reads every message, including those of aborted and
+	// in-flight transactions.
+	ReadUncommitted IsolationLevel = iota
+	// ReadCommitted only reads the messages of committed transactions.
+	ReadCommitted
+)
+
 type ReaderOpts struct {
+	ClientOpts
+
 	ServiceName string
 	// The list of broker addresses used to connect to the kafka cluster.
 	Brokers []string
@@ -58,16 +99,64 @@ type ReaderOpts struct {
 
 	CommitInterval int
 
+	// RebalanceStrategy is the partition assignment strategy, default RebalanceRoundRobin.
+	RebalanceStrategy RebalanceStrategy
+
+	// FetchMin, FetchDefault and FetchMax bound the number of bytes fetched
+	// per request and partition, the sarama defaults are used when zero.
+	FetchMin     int32
+	FetchDefault int32
+	FetchMax     int32
+
+	// SessionTimeout is the timeout after which the member is removed from
+	// the group when no heartbeat was received.
+	SessionTimeout time.Duration
+
+	// HeartbeatInterval is the interval between two heartbeats to the group coordinator.
+	HeartbeatInterval time.Duration
+
+	// Metrics instruments the handler when set.
+	Metrics *Metrics
+
+	// IsolationLevel controls whether messages of aborted and in-flight
+	// transactions are read, default ReadUncommitted.
+	IsolationLevel IsolationLevel
+
+	// DrainTimeout bounds how long Close waits for the in-flight handlers to
+	// return and their offsets to be committed, default 10s.
+	DrainTimeout time.Duration
+
+	// OnAssigned is called when partitions are assigned to the reader,
+	// before any of their messages is handled.
+	OnAssigned PartitionHook
+
+	// OnRevoked is called when partitions are revoked from the reader, once
+	// all their handlers returned and before the offsets are committed.
+	OnRevoked PartitionHook
+
+	// Backpressure pauses the partitions while the handlers are saturated
+	// or failing when set.
+	Backpressure *Backpressure
+
+	// Middlewares wrap the handler, the first one is the outermost. They run
+	// within the consumer span, after the message was decoded.
+	Middlewares []Middleware
+
 	Logger logger.Logger
 }
 
+// PartitionHook is called on rebalance with the partitions assigned to or
+// revoked from the reader, by topic. An error ends the session.
+type PartitionHook func(session sarama.ConsumerGroupSession, partitions map[string][]int32) error
+
 func newReaderOptions(brokers []string, topic, group string, opts ...ReaderOpt) *ReaderOpts {
 	opt := &ReaderOpts{
-		ServiceName: defaultConsumerServiceName,
-		Brokers:     brokers,
-		Topic:       topic,
-		GroupID:     group,
-		StartOffset: OffsetOldest,
+		ServiceName:  defaultConsumerServiceName,
+		Brokers:      brokers,
+		Topic:        topic,
+		GroupID:      group,
+		StartOffset:  OffsetOldest,
+		DrainTimeout: defaultDrainTimeout,
 	}
 
 	for _, o := range opts {
@@ -89,8 +178,6 @@ func newReaderOptions(brokers []string, topic, group string, opts ...ReaderOpt)
 		)
 	}
 
-	InitLogger(opt.Logger)
-
 	return opt
 }
 
@@ -114,6 +201,126 @@ func ReaderCommitInterval(commitInterval int) ReaderOpt {
 	}
 }
 
+// ReaderIsolationLevel sets the isolation level of the consumer, use
+// ReadCommitted to only read the messages of committed transactions.
+func ReaderIsolationLevel(level IsolationLevel) ReaderOpt {
+	return func(o *ReaderOpts) {
+		o.IsolationLevel = level
+	}
+}
+
+// ReaderVersion sets the version of the brokers, e.g. "2.8.1".
+func ReaderVersion(version string) ReaderOpt {
+	return func(o *ReaderOpts) {
+		o.Version = version
+	}
+}
+
+// ReaderRebalanceStrategy sets the partition assignment strategy.
+func ReaderRebalanceStrategy(strategy RebalanceStrategy) ReaderOpt {
+	return func(o *ReaderOpts) {
+		o.RebalanceStrategy = strategy
+	}
+}
+
+// ReaderFetch sets the minimum, default and maximum number of bytes fetched
+// per request, zero keeps the sarama default.
+func ReaderFetch(min, def, max int32) ReaderOpt {
+	return func(o *ReaderOpts) {
+		o.FetchMin = min
+		o.FetchDefault = def
+		o.FetchMax = max
+	}
+}
+
+// ReaderSessionTimeout sets the consumer group session timeout.
+func ReaderSessionTimeout(timeout time.Duration) ReaderOpt {
+	return func(o *ReaderOpts) {
+		o.SessionTimeout = timeout
+	}
+}
+
+// ReaderHeartbeatInterval sets the consumer group heartbeat interval.
+func ReaderHeartbeatInterval(interval time.Duration) ReaderOpt {
+	return func(o *ReaderOpts) {
+		o.HeartbeatInterval = interval
+	}
+}
+
+// ReaderSaramaConfig mutates the generated sarama config before the consumer is created.
+func ReaderSaramaConfig(fn func(config *sarama.Config)) ReaderOpt {
+	return func(o *ReaderOpts) {
+		o.ConfigFunc = fn
+	}
+}
+
+// ReaderMetrics instruments the reader, e.g. ReaderMetrics(PrometheusMetrics()).
+func ReaderMetrics(metrics *Metrics) ReaderOpt {
+	return func(o *ReaderOpts) {
+		o.Metrics = metrics
+	}
+}
+
+// ReaderTLS enables TLS towards the brokers.
+func ReaderTLS(config *TLSConfig) ReaderOpt {
+	return func(o *ReaderOpts) {
+		o.TLS = config
+	}
+}
+
+// ReaderSASL enables SASL authentication towards the brokers.
+func ReaderSASL(config *SASLConfig) ReaderOpt {
+	return func(o *ReaderOpts) {
+		o.SASL = config
+	}
+}
+
+// ReaderDrainTimeout sets how long Close waits for the in-flight handlers,
+// zero closes immediately.
+func ReaderDrainTimeout(timeout time.Duration) ReaderOpt {
+	return func(o *ReaderOpts) {
+		o.DrainTimeout = timeout
+	}
+}
+
+// ReaderOnAssigned sets the hook called with the partitions assigned on rebalance.
+func ReaderOnAssigned(hook PartitionHook) ReaderOpt {
+	return func(o *ReaderOpts) {
+		o.OnAssigned = hook
+	}
+}
+
+// ReaderOnRevoked sets the hook called with the partitions revoked on
+// rebalance and on Close, e.g. to flush per-partition state.
+func ReaderOnRevoked(hook PartitionHook) ReaderOpt {
+	return func(o *ReaderOpts) {
+		o.OnRevoked = hook
+	}
+}
+
+// ReaderBackpressure pauses the partitions while the handlers are saturated
+// or failing, see Backpressure.
+func ReaderBackpressure(config Backpressure) ReaderOpt {
+	return func(o *ReaderOpts) {
+		o.Backpressure = &config
+	}
+}
+
+// ReaderMiddleware appends middlewares wrapping the handler, e.g.
+// ReaderMiddleware(Recovery(), Timeout(time.Minute)).
+func ReaderMiddleware(m ...Middleware) ReaderOpt {
+	return func(o *ReaderOpts) {
+		o.Middlewares = append(o.Middlewares, m...)
+	}
+}
+
+// ReaderDebug enables the sarama debug logs while the reader is open.
+func ReaderDebug(debug bool) ReaderOpt {
+	return func(o *ReaderOpts) {
+		o.Debug = debug
+	}
+}
+
 func ReaderLogger(logger logger.Logger) ReaderOpt {
 	return func(o *ReaderOpts) {
 		o.Logger = logger
@@ -133,7 +340,35 @@ const (
 	WaitForAll RequiredAck = -1
 )
 
+// Compression is the codec used to compress the message batches.
+type Compression int8
+
+const (
+	CompressionNone Compression = iota
+	CompressionGZIP
+	CompressionSnappy
+	CompressionLZ4
+	CompressionZSTD
+)
+
+func (c Compression) codec() sarama.CompressionCodec {
+	switch c {
+	case CompressionGZIP:
+		return sarama.CompressionGZIP
+	case CompressionSnappy:
+		return sarama.CompressionSnappy
+	case CompressionLZ4:
+		return sarama.CompressionLZ4
+	case CompressionZSTD:
+		return sarama.CompressionZSTD
+	default:
+		return sarama.CompressionNone
+	}
+}
+
 type WriterOpts struct {
+	ClientOpts
+
 	ServiceName string
 	Brokers     []string
 	// Limit on how many attempts will be made to deliver a message.
@@ -155,17 +390,64 @@ type WriterOpts struct {
 
 	Async bool
 
+	// Idempotent makes the producer retry without duplicating or reordering
+	// messages, it forces RequiredAck to WaitForAll.
+	Idempotent bool
+
+	// TransactionID is the transactional id of a TxnWriter.
+	TransactionID string
+
+	// Compression is the codec of the message batches, default CompressionNone.
+	Compression Compression
+
+	// FlushBytes, FlushMessages and FlushFrequency are the best-effort
+	// thresholds triggering the send of a batch, unset when zero.
+	FlushBytes     int
+	FlushMessages  int
+	FlushFrequency time.Duration
+
+	// Partitioner selects the partition of each message, default RandomPartitioner.
+	Partitioner Partitioner
+
+	// ReturnSuccesses, if enabled, delivers the messages acknowledged by the
+	// async producer on the Messages channel, which must then be read.
+	ReturnSuccesses bool
+
+	// ReturnErrors, if enabled, delivers the messages the async producer failed
+	// to deliver on the Errors channel, which must then be read.
+	ReturnErrors bool
+
+	// Metrics instruments the delivery of the messages when set.
+	Metrics *Metrics
+
+	// Callback is called once for every message when its delivery has
+	// succeeded or failed.
+	Callback Callback
+
+	// Interceptors wrap the sending of the messages, the first one is the
+	// outermost.
+	Interceptors []Interceptor
+
+	// MaxMessageBytes is the maximum size of a message, default 1M. It must
+	// not exceed the message.max.bytes of the brokers.
+	MaxMessageBytes int
+
 	Logger logger.Logger
 }
 
+// Callback is called with the delivery report of a message, err is nil when
+// the message was delivered successfully.
+type Callback func(message *ProducerMessage, err error)
+
 type WriterOpt func(o *WriterOpts)
 
 func newWriterOptions(brokers []string, opts ...WriterOpt) WriterOpts {
 	opt := WriterOpts{
-		ServiceName: defaultProducerServiceName,
-		Brokers:     brokers,
-		RequiredAck: WaitForAll,
-		MaxAttempts: 3,
+		ServiceName:     defaultProducerServiceName,
+		Brokers:         brokers,
+		RequiredAck:     WaitForAll,
+		MaxAttempts:     3,
+		MaxMessageBytes: defaultMaxMessageBytes,
 	}
 	for _, o := range opts {
 		o(&opt)
@@ -183,8 +465,6 @@ func newWriterOptions(brokers []string, opts ...WriterOpt) WriterOpts {
 		)
 	}
 
-	InitLogger(opt.Logger)
-
 	return opt
 }
 
@@ -218,6 +498,116 @@ func WriterAsync(async bool) WriterOpt {
 	}
 }
 
+// WriterVersion sets the version of the brokers, e.g. "2.8.1".
+func WriterVersion(version string) WriterOpt {
+	return func(o *WriterOpts) {
+		o.Version = version
+	}
+}
+
+// WriterCompression sets the codec used to compress the message batches.
+func WriterCompression(compression Compression) WriterOpt {
+	return func(o *WriterOpts) {
+		o.Compression = compression
+	}
+}
+
+// WriterFlush sets the number of bytes, the number of messages and the
+// frequency triggering the send of a batch, zero leaves a threshold unset.
+func WriterFlush(bytes, messages int, frequency time.Duration) WriterOpt {
+	return func(o *WriterOpts) {
+		o.FlushBytes = bytes
+		o.FlushMessages = messages
+		o.FlushFrequency = frequency
+	}
+}
+
+// WriterSaramaConfig mutates the generated sarama config before the producer is created.
+func WriterSaramaConfig(fn func(config *sarama.Config)) WriterOpt {
+	return func(o *WriterOpts) {
+		o.ConfigFunc = fn
+	}
+}
+
+// WriterIdempotent enables the idempotent producer.
+func WriterIdempotent(idempotent bool) WriterOpt {
+	return func(o *WriterOpts) {
+		o.Idempotent = idempotent
+	}
+}
+
+// WriterPartitioner sets the strategy used to pick the partition of a message.
+func WriterPartitioner(partitioner Partitioner) WriterOpt {
+	return func(o *WriterOpts) {
+		o.Partitioner = partitioner
+	}
+}
+
+// WriterReturnSuccesses surfaces the async delivery successes on Writer.Messages.
+func WriterReturnSuccesses(enable bool) WriterOpt {
+	return func(o *WriterOpts) {
+		o.ReturnSuccesses = enable
+	}
+}
+
+// WriterReturnErrors surfaces the async delivery errors on Writer.Errors.
+func WriterReturnErrors(enable bool) WriterOpt {
+	return func(o *WriterOpts) {
+		o.ReturnErrors = enable
+	}
+}
+
+// WriterCallback sets the callback invoked with the delivery report of every message.
+func WriterCallback(callback Callback) WriterOpt {
+	return func(o *WriterOpts) {
+		o.Callback = callback
+	}
+}
+
+// WriterMetrics instruments the writer, e.g. WriterMetrics(PrometheusMetrics()).
+func WriterMetrics(metrics *Metrics) WriterOpt {
+	return func(o *WriterOpts) {
+		o.Metrics = metrics
+	}
+}
+
+// WriterTLS enables TLS towards the brokers.
+func WriterTLS(config *TLSConfig) WriterOpt {
+	return func(o *WriterOpts) {
+		o.TLS = config
+	}
+}
+
+// WriterSASL enables SASL authentication towards the brokers.
+func WriterSASL(config *SASLConfig) WriterOpt {
+	return func(o *WriterOpts) {
+		o.SASL = config
+	}
+}
+
+// WriterInterceptor appends interceptors wrapping the sending of the
+// messages, e.g. WriterInterceptor(ClientMetadata(), MaxMessageBytes(1<<20)).
+func WriterInterceptor(i ...Interceptor) WriterOpt {
+	return func(o *WriterOpts) {
+		o.Interceptors = append(o.Interceptors, i...)
+	}
+}
+
+// WriterMaxMessageBytes sets the maximum size of a message, larger messages
+// are rejected by the producer, see Chunk and ClaimCheck to send them.
+func WriterMaxMessageBytes(max int) WriterOpt {
+	return func(o *WriterOpts) {
+		o.MaxMessageBytes = max
+	}
+}
+
+// WriterDebug enables the sarama debug logs while the writer is open.
+func WriterDebug(debug bool) WriterOpt {
+	return func(o *WriterOpts) {
+		o.Debug = debug
+	}
+}
+
 func WriterLogger(logger logger.Logger) WriterOpt {
 	return func(o *WriterOpts) {
 		o.Logger = logger
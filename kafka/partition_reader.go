@@ -0,0 +1,313 @@
+package kafka
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Shopify/sarama"
+)
+
+// partitionIdleCheck is the interval at which a partition read up to the
+// high water mark without reaching its end offset is checked for completion,
+// e.g. when the last offsets are transaction markers.
+const partitionIdleCheck = 500 * time.Millisecond
+
+// PartitionRange is the range of offsets read from a partition.
+type PartitionRange struct {
+	Partition int32
+
+	// Start is the first offset read, or int64(OffsetOldest) or
+	// int64(OffsetNewest).
+	Start int64
+
+	// StartTime, when set, overrides Start with the offset of the first
+	// message produced at or after it.
+	StartTime time.Time
+
+	// End is the offset following the last one read, or int64(OffsetNewest)
+	// for the high water mark when Run is called. An End beyond the high
+	// water mark waits for the messages to be produced.
+	End int64
+}
+
+// PartitionReader reads ranges of offsets of the partitions of a topic
+// without joining a consumer group, e.g. to replay or backfill messages.
+// The handlers are traced, instrumented and wrapped by the middlewares as
+// those of a Reader. Their session has no group, marking offsets is a no-op.
+type PartitionReader interface {
+	// Run handles the messages of the ranges, in order within a partition
+	// and concurrently across partitions, and returns once every range was
+	// read, a handler failed or ctx is done.
+	Run(ctx context.Context, handler Handler) error
+	Close() error
+}
+
+type partitionReader struct {
+	core     *reader
+	ranges   []PartitionRange
+	consumer sarama.Consumer
+	// offset returns the offset of partition at a timestamp in milliseconds,
+	// or sarama.OffsetOldest or sarama.OffsetNewest.
+	offset func(partition int32, at int64) (int64, error)
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	closed int32
+	// release closes the clients backing consumer.
+	release func() error
+}
+
+// NewPartitionReader reads ranges of the partitions of topic, every
+// partition from the oldest offset to the high water mark when ranges is
+// empty. The group related options are ignored.
+func NewPartitionReader(brokers []string, topic string, ranges []PartitionRange, opts ...ReaderOpt) (PartitionReader, error) {
+	options := newReaderOptions(brokers, topic, "", opts...)
+	config, err := newConsumerConfig(options)
+	if err != nil {
+		return nil, err
+	}
+
+	unregisterLogs := options.registerLogs()
+	client, err := sarama.NewClient(options.Brokers, config)
+	if err != nil {
+		unregisterLogs()
+		return nil, err
+	}
+	consumer, err := sarama.NewConsumerFromClient(client)
+	if err != nil {
+		_ = client.Close()
+		unregisterLogs()
+		return nil, err
+	}
+
+	r := newPartitionReader(options, ranges, consumer, func(partition int32, at int64) (int64, error) {
+		return client.GetOffset(topic, partition, at)
+	})
+	r.release = func() error {
+		defer unregisterLogs()
+		return client.Close()
+	}
+	return r, nil
+}
+
+func newPartitionReader(options *ReaderOpts, ranges []PartitionRange, consumer sarama.Consumer, offset func(partition int32, at int64) (int64, error)) *partitionReader {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &partitionReader{
+		core: &reader{
+			opts:     options,
+			close:    make(chan bool),
+			consumed: make(chan struct{}),
+			flow:     newFlowControl(nil, options.Logger),
+		},
+		ranges:   ranges,
+		consumer: consumer,
+		offset:   offset,
+		ctx:      ctx,
+		cancel:   cancel,
+	}
+}
+
+func (r *partitionReader) Run(ctx context.Context, handler Handler) error {
+	if atomic.LoadInt32(&r.closed) != 0 {
+		return io.EOF
+	}
+
+	ranges, err := r.resolve()
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		select {
+		case <-r.ctx.Done():
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	claims := map[string][]int32{r.core.opts.Topic: {}}
+	for _, pr := range ranges {
+		claims[r.core.opts.Topic] = append(claims[r.core.opts.Topic], pr.Partition)
+	}
+	session := &partitionSession{ctx: ctx, claims: claims}
+	handler = Chain(r.core.opts.Middlewares...)(handler)
+
+	var (
+		wg    sync.WaitGroup
+		once  sync.Once
+		first error
+	)
+	for _, pr := range ranges {
+		wg.Add(1)
+		go func(pr PartitionRange) {
+			defer wg.Done()
+			if err := r.read(session, pr, handler); err != nil {
+				once.Do(func() {
+					first = err
+					cancel()
+				})
+			}
+		}(pr)
+	}
+	wg.Wait()
+
+	if first != nil {
+		return first
+	}
+	return ctx.Err()
+}
+
+// resolve returns the ranges with explicit start and end offsets, without
+// the empty ones.
+func (r *partitionReader) resolve() ([]PartitionRange, error) {
+	ranges := r.ranges
+	if len(ranges) == 0 {
+		partitions, err := r.consumer.Partitions(r.core.opts.Topic)
+		if err != nil {
+			return nil, err
+		}
+		for _, partition := range partitions {
+			ranges = append(ranges, PartitionRange{Partition: partition, Start: sarama.OffsetOldest, End: sarama.OffsetNewest})
+		}
+	}
+
+	resolved := make([]PartitionRange, 0, len(ranges))
+	for _, pr := range ranges {
+		var err error
+		switch {
+		case !pr.StartTime.IsZero():
+			pr.Start, err = r.offset(pr.Partition, pr.StartTime.UnixMilli())
+			if err == nil && pr.Start < 0 {
+				// No message was produced after StartTime.
+				pr.Start, err = r.offset(pr.Partition, sarama.OffsetNewest)
+			}
+		case pr.Start == sarama.OffsetOldest || pr.Start == sarama.OffsetNewest:
+			pr.Start, err = r.offset(pr.Partition, pr.Start)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		switch {
+		case pr.End == sarama.OffsetNewest:
+			if pr.End, err = r.offset(pr.Partition, sarama.OffsetNewest); err != nil {
+				return nil, err
+			}
+		case pr.End < 0:
+			return nil, fmt.Errorf("kafka: invalid end offset %d of partition %d", pr.End, pr.Partition)
+		}
+		if pr.Start < pr.End {
+			resolved = append(resolved, pr)
+		}
+	}
+	return resolved, nil
+}
+
+// read handles the messages of a partition from pr.Start up to pr.End.
+func (r *partitionReader) read(session *partitionSession, pr PartitionRange, handler Handler) error {
+	pc, err := r.consumer.ConsumePartition(r.core.opts.Topic, pr.Partition, pr.Start)
+	if err != nil {
+		return err
+	}
+	defer pc.AsyncClose()
+
+	claim := &partitionClaim{topic: r.core.opts.Topic, partition: pr.Partition, initial: pr.Start, consumer: pc}
+
+	ticker := time.NewTicker(partitionIdleCheck)
+	defer ticker.Stop()
+	idle, errs := false, pc.Errors()
+	for {
+		select {
+		case <-session.ctx.Done():
+			return nil
+		case err, ok := <-errs:
+			if !ok {
+				errs = nil
+				continue
+			}
+			return err
+		case message, ok := <-pc.Messages():
+			if !ok || message.Offset >= pr.End {
+				return nil
+			}
+			idle = false
+			if err := r.core.handle(message, session, claim, handler); err != nil {
+				return err
+			}
+			if message.Offset+1 >= pr.End {
+				return nil
+			}
+		case <-ticker.C:
+			// Nothing was received for a whole interval while the high
+			// water mark reached the end, the remaining offsets hold no
+			// message to handle.
+			if idle && pc.HighWaterMarkOffset() >= pr.End {
+				return nil
+			}
+			idle = true
+		}
+	}
+}
+
+func (r *partitionReader) Close() error {
+	if !atomic.CompareAndSwapInt32(&r.closed, 0, 1) {
+		return nil
+	}
+
+	r.cancel()
+	err := r.consumer.Close()
+	if r.release != nil {
+		if e := r.release(); err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+// partitionSession is the session of the handlers of a PartitionReader,
+// there is no group to commit offsets to.
+type partitionSession struct {
+	ctx    context.Context
+	claims map[string][]int32
+}
+
+func (s *partitionSession) Claims() map[string][]int32 { return s.claims }
+
+func (s *partitionSession) MemberID() string { return "" }
+
+func (s *partitionSession) GenerationID() int32 { return 0 }
+
+func (s *partitionSession) MarkOffset(topic string, partition int32, offset int64, metadata string) {}
+
+func (s *partitionSession) Commit() {}
+
+func (s *partitionSession) ResetOffset(topic string, partition int32, offset int64, metadata string) {
+}
+
+func (s *partitionSession) MarkMessage(msg *sarama.ConsumerMessage, metadata string) {}
+
+func (s *partitionSession) Context() context.Context { return s.ctx }
+
+// partitionClaim is the sarama.ConsumerGroupClaim of a partition consumer.
+type partitionClaim struct {
+	topic     string
+	partition int32
+	initial   int64
+	consumer  sarama.PartitionConsumer
+}
+
+func (c *partitionClaim) Topic() string { return c.topic }
+
+func (c *partitionClaim) Partition() int32 { return c.partition }
+
+func (c *partitionClaim) InitialOffset() int64 { return c.initial }
+
+func (c *partitionClaim) HighWaterMarkOffset() int64 { return c.consumer.HighWaterMarkOffset() }
+
+func (c *partitionClaim) Messages() <-chan *sarama.ConsumerMessage { return c.consumer.Messages() }
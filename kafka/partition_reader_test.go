@@ -0,0 +1,82 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/Shopify/sarama/mocks"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPartitionReader(t *testing.T) {
+	consumer := mocks.NewConsumer(t, nil)
+	consumer.SetTopicMetadata(map[string][]int32{"t": {0, 1, 2}})
+	startTime := time.Now().Add(-time.Hour)
+	offsets := func(partition int32, at int64) (int64, error) {
+		switch at {
+		case sarama.OffsetOldest:
+			return 0, nil
+		case sarama.OffsetNewest:
+			return 4, nil
+		case startTime.UnixMilli():
+			return 2, nil
+		}
+		return -1, fmt.Errorf("unexpected offset request %d", at)
+	}
+	yield := func(pc *mocks.PartitionConsumer, n int) {
+		for i := 0; i < n; i++ {
+			pc.YieldMessage(&sarama.ConsumerMessage{Value: []byte("v")})
+		}
+	}
+	yield(consumer.ExpectConsumePartition("t", 0, 2), 3)
+	yield(consumer.ExpectConsumePartition("t", 1, 0), 2)
+	// The last offset of the partition is a transaction marker.
+	yield(consumer.ExpectConsumePartition("t", 2, 0), 1)
+
+	r := newPartitionReader(newReaderOptions(nil, "t", ""), []PartitionRange{
+		{Partition: 0, StartTime: startTime, End: sarama.OffsetNewest},
+		{Partition: 1, Start: sarama.OffsetOldest, End: 1},
+		{Partition: 2, Start: 0, End: 2},
+	}, consumer, offsets)
+	defer r.Close()
+
+	var (
+		mutex sync.Mutex
+		read  []string
+	)
+	err := r.Run(context.Background(), func(ctx context.Context, session sarama.ConsumerGroupSession, message *ConsumerMessage) error {
+		mutex.Lock()
+		defer mutex.Unlock()
+		read = append(read, fmt.Sprintf("%d/%d", message.Partition, message.Offset))
+		return nil
+	})
+	assert.Nil(t, err)
+	sort.Strings(read)
+	assert.Equal(t, []string{"0/2", "0/3", "1/0", "2/0"}, read)
+}
+
+func TestPartitionReader_HandlerError(t *testing.T) {
+	consumer := mocks.NewConsumer(t, nil)
+	consumer.SetTopicMetadata(map[string][]int32{"t": {0}})
+	consumer.ExpectConsumePartition("t", 0, 0).YieldMessage(&sarama.ConsumerMessage{Value: []byte("v")})
+
+	r := newPartitionReader(newReaderOptions(nil, "t", "", ReaderMiddleware(Recovery())), nil, consumer, func(partition int32, at int64) (int64, error) {
+		if at == sarama.OffsetNewest {
+			return 10, nil
+		}
+		return 0, nil
+	})
+	defer r.Close()
+
+	err := r.Run(context.Background(), func(ctx context.Context, session sarama.ConsumerGroupSession, message *ConsumerMessage) error {
+		panic("boom")
+	})
+	var panicErr *PanicError
+	assert.True(t, errors.As(err, &panicErr))
+}
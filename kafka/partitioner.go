@@ -0,0 +1,137 @@
+package kafka
+
+import (
+	"github.com/Shopify/sarama"
+)
+
+// Partitioner selects how the writer assigns a partition to each message.
+type Partitioner int
+
+const (
+	// RandomPartitioner sends every message to a random partition.
+	RandomPartitioner Partitioner = iota
+	// HashPartitioner hashes the message key (FNV-1a) to pick the partition,
+	// messages with the same key always land on the same partition.
+	HashPartitioner
+	// RoundRobinPartitioner walks through the available partitions one at a time.
+	RoundRobinPartitioner
+	// ManualPartitioner sends the message to ProducerMessage.Partition.
+	ManualPartitioner
+	// ConsistentPartitioner hashes the message key with murmur2, the same way
+	// the Java client's default partitioner does, so both clients agree on the
+	// partition of a key.
+	ConsistentPartitioner
+)
+
+func (p Partitioner) constructor() sarama.PartitionerConstructor {
+	switch p {
+	case HashPartitioner:
+		return sarama.NewHashPartitioner
+	case RoundRobinPartitioner:
+		return chunkPartitioned(sarama.NewRoundRobinPartitioner)
+	case ManualPartitioner:
+		return sarama.NewManualPartitioner
+	case ConsistentPartitioner:
+		return newMurmur2Partitioner
+	default:
+		return chunkPartitioned(sarama.NewRandomPartitioner)
+	}
+}
+
+// chunkPartitioned hashes the key of the chunks sent by Chunk instead of
+// using constructor, which spreads the messages regardless of their key, so
+// that all the chunks of a message land on the same partition.
+func chunkPartitioned(constructor sarama.PartitionerConstructor) sarama.PartitionerConstructor {
+	return func(topic string) sarama.Partitioner {
+		return &chunkPartitioner{Partitioner: constructor(topic), chunks: sarama.NewHashPartitioner(topic)}
+	}
+}
+
+type chunkPartitioner struct {
+	sarama.Partitioner
+	chunks sarama.Partitioner
+}
+
+func (p *chunkPartitioner) Partition(message *sarama.ProducerMessage, numPartitions int32) (int32, error) {
+	if isChunk(message) {
+		return p.chunks.Partition(message, numPartitions)
+	}
+	return p.Partitioner.Partition(message, numPartitions)
+}
+
+// MessageRequiresConsistency keeps the chunks on their partition while it is
+// unavailable, rather than retrying them on another one.
+func (p *chunkPartitioner) MessageRequiresConsistency(message *sarama.ProducerMessage) bool {
+	return isChunk(message) || p.Partitioner.RequiresConsistency()
+}
+
+func isChunk(message *sarama.ProducerMessage) bool {
+	for _, h := range message.Headers {
+		if string(h.Key) == ChunkIDHeader {
+			return true
+		}
+	}
+	return false
+}
+
+type murmur2Partitioner struct {
+	random sarama.Partitioner
+}
+
+func newMurmur2Partitioner(topic string) sarama.Partitioner {
+	return &murmur2Partitioner{random: sarama.NewRandomPartitioner(topic)}
+}
+
+func (p *murmur2Partitioner) Partition(message *sarama.ProducerMessage, numPartitions int32) (int32, error) {
+	if message.Key == nil {
+		return p.random.Partition(message, numPartitions)
+	}
+	key, err := message.Key.Encode()
+	if err != nil {
+		return -1, err
+	}
+	return int32(murmur2(key)&0x7fffffff) % numPartitions, nil
+}
+
+func (p *murmur2Partitioner) RequiresConsistency() bool {
+	return true
+}
+
+// murmur2 is the 32-bit murmur2 hash used by the Java client
+// (org.apache.kafka.common.utils.Utils#murmur2).
+func murmur2(data []byte) uint32 {
+	const (
+		seed uint32 = 0x9747b28c
+		m    uint32 = 0x5bd1e995
+		r           = 24
+	)
+
+	length := len(data)
+	h := seed ^ uint32(length)
+	for i := 0; i+4 <= length; i += 4 {
+		k := uint32(data[i]) | uint32(data[i+1])<<8 | uint32(data[i+2])<<16 | uint32(data[i+3])<<24
+		k *= m
+		k ^= k >> r
+		k *= m
+		h *= m
+		h ^= k
+	}
+
+	tail := length &^ 3
+	switch length % 4 {
+	case 3:
+		h ^= uint32(data[tail+2]) << 16
+		fallthrough
+	case 2:
+		h ^= uint32(data[tail+1]) << 8
+		fallthrough
+	case 1:
+		h ^= uint32(data[tail])
+		h *= m
+	}
+
+	h ^= h >> 13
+	h *= m
+	h ^= h >> 15
+	return h
+}
@@ -0,0 +1,36 @@
+package kafka
+
+import (
+	"testing"
+
+	"github.com/Shopify/sarama"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMurmur2(t *testing.T) {
+	// Vectors from the Java client's UtilsTest#testMurmur2.
+	cases := map[string]int32{
+		"21":                         -973932308,
+		"foobar":                     -790332482,
+		"a-little-bit-long-string":   -985981536,
+		"a-little-bit-longer-string": -1486304829,
+		"lkjh234lh9fiuh90y23oiuhsafujhadof229phr9h19h89h8": -58897971,
+		"abc": 479470107,
+	}
+	for key, want := range cases {
+		assert.Equal(t, want, int32(murmur2([]byte(key))), key)
+	}
+}
+
+func TestConsistentPartitioner(t *testing.T) {
+	p := ConsistentPartitioner.constructor()("test")
+	msg := &sarama.ProducerMessage{Key: sarama.StringEncoder("foobar")}
+	first, err := p.Partition(msg, 12)
+	assert.Nil(t, err)
+	for i := 0; i < 10; i++ {
+		partition, err := p.Partition(msg, 12)
+		assert.Nil(t, err)
+		assert.Equal(t, first, partition)
+	}
+	assert.Equal(t, int32((-790332482&0x7fffffff)%12), first)
+}
@@ -21,6 +21,13 @@ import (
 type Reader interface {
 	FetchMessage(ctx context.Context, handler Handler) error
 	CommitMessage(ctx context.Context, session sarama.ConsumerGroupSession, message *ConsumerMessage) error
+	// Pause stops fetching from partitions of topic, all the assigned ones
+	// when none is given, without leaving the group. The partitions stay
+	// paused across rebalances until resumed.
+	Pause(topic string, partitions ...int32)
+	// Resume resumes fetching from partitions of topic, all of them when
+	// none is given.
+	Resume(topic string, partitions ...int32)
 	Close() error
 }
 
@@ -34,6 +41,13 @@ type reader struct {
 	close    chan bool
 	closed   int32
 	consumer sarama.ConsumerGroup
+	// consumed is closed once the consume loop returned
+	consumed chan struct{}
+	// handlers counts the in-flight handlers, none is added once closed
+	handlers sync.WaitGroup
+	flow     *flowControl
+	// unregisterLogs disables the sarama debug logs enabled by the reader.
+	unregisterLogs func()
 
 	handler Handler
 }
@@ -42,53 +56,93 @@ type Handler func(ctx context.Context, session sarama.ConsumerGroupSession, mess
 
 func NewReader(brokers []string, topic, group string, opts ...ReaderOpt) (Reader, error) {
 	reader := &reader{
-		close: make(chan bool),
+		close:    make(chan bool),
+		consumed: make(chan struct{}),
 	}
 
 	reader.opts = newReaderOptions(brokers, topic, group, opts...)
-	config := sarama.NewConfig()
-	config.Version = sarama.V3_0_0_0
-	config.ClientID = reader.opts.ServiceName
-	config.Consumer.Offsets.Initial = int64(reader.opts.StartOffset)
-	config.Consumer.Return.Errors = true
-	if reader.opts.CommitInterval > 0 { // 设置自动提交offset间隔,默认10s
-		config.Consumer.Offsets.AutoCommit.Enable = true
-		config.Consumer.Offsets.AutoCommit.Interval = time.Duration(reader.opts.CommitInterval) * time.Second
-	} else {
-		config.Consumer.Offsets.AutoCommit.Enable = true
-		config.Consumer.Offsets.AutoCommit.Interval = 1 * time.Second
+	reader.flow = newFlowControl(reader.opts.Backpressure, reader.opts.Logger)
+	config, err := newConsumerConfig(reader.opts)
+	if err != nil {
+		return nil, err
 	}
-	config.Producer.MaxMessageBytes = int(sarama.MaxRequestSize - 1) // 1M
-	config.Consumer.Group.Rebalance.Strategy = sarama.BalanceStrategyRoundRobin
+	reader.unregisterLogs = reader.opts.registerLogs()
 	client, err := sarama.NewConsumerGroup(reader.opts.Brokers, group, config)
 	if err != nil {
+		reader.unregisterLogs()
 		return nil, err
 	}
 
+	reader.start(client)
+
+	return reader, nil
+}
+
+// start consumes the topic with client until the reader is closed.
+func (r *reader) start(client sarama.ConsumerGroup) {
 	ctx, cancel := context.WithCancel(context.Background())
-	reader.ctx = ctx
-	reader.Cancel = cancel
-	reader.consumer = client
+	r.ctx = ctx
+	r.Cancel = cancel
+	r.consumer = client
+	r.flow.setPauser(client)
 
 	go func() {
+		defer close(r.consumed)
 		for {
 			// `Consume` should be called inside an infinite loop, when a
 			// server-side rebalance happens, the consumer session will need to be
 			// recreated to get the new claims
-			if err := client.Consume(ctx, []string{reader.opts.Topic}, otelsarama.WrapConsumerGroupHandler(reader)); err != nil {
-				reader.opts.Logger.Errorf("consume error: %v", err)
+			if err := client.Consume(ctx, []string{r.opts.Topic}, otelsarama.WrapConsumerGroupHandler(r)); err != nil {
+				r.opts.Logger.Errorf("consume error: %v", err)
 			}
 
-			// check if context was cancelled, signaling that the consumer should stop
-			if ctx.Err() != nil {
+			// check if context was cancelled or the reader closed, signaling
+			// that the consumer should stop
+			if ctx.Err() != nil || r.isClosed() {
 				return
 			}
 		}
 	}()
 
-	go reader.eventNotification()
+	go r.eventNotification()
+}
 
-	return reader, nil
+func newConsumerConfig(options *ReaderOpts) (*sarama.Config, error) {
+	config, err := newClientConfig(options.ServiceName, options.ClientOpts)
+	if err != nil {
+		return nil, err
+	}
+	config.Consumer.Offsets.Initial = int64(options.StartOffset)
+	config.Consumer.Return.Errors = true
+	config.Consumer.IsolationLevel = sarama.IsolationLevel(options.IsolationLevel)
+	if options.CommitInterval > 0 { // 设置自动提交offset间隔,默认10s
+		config.Consumer.Offsets.AutoCommit.Enable = true
+		config.Consumer.Offsets.AutoCommit.Interval = time.Duration(options.CommitInterval) * time.Second
+	} else {
+		config.Consumer.Offsets.AutoCommit.Enable = true
+		config.Consumer.Offsets.AutoCommit.Interval = 1 * time.Second
+	}
+	config.Consumer.Group.Rebalance.Strategy = options.RebalanceStrategy.balanceStrategy()
+	if options.FetchMin > 0 {
+		config.Consumer.Fetch.Min = options.FetchMin
+	}
+	if options.FetchDefault > 0 {
+		config.Consumer.Fetch.Default = options.FetchDefault
+	}
+	if options.FetchMax > 0 {
+		config.Consumer.Fetch.Max = options.FetchMax
+	}
+	if options.SessionTimeout > 0 {
+		config.Consumer.Group.Session.Timeout = options.SessionTimeout
+	}
+	if options.HeartbeatInterval > 0 {
+		config.Consumer.Group.Heartbeat.Interval = options.HeartbeatInterval
+	}
+	if options.ConfigFunc != nil {
+		options.ConfigFunc(config)
+	}
+
+	return config, nil
 }
 
 func (r *reader) eventNotification() {
@@ -114,7 +168,7 @@ func (r *reader) FetchMessage(ctx context.Context, handler Handler) error {
 		return io.EOF
 	}
 
-	r.handler = handler
+	r.handler = Chain(r.opts.Middlewares...)(handler)
 	return nil
 }
 
@@ -123,23 +177,68 @@ func (r *reader) CommitMessage(ctx context.Context, session sarama.ConsumerGroup
 	return nil
 }
 
-func (r *reader) markClosed() {
-	atomic.StoreInt32(&r.closed, 1)
+func (r *reader) Pause(topic string, partitions ...int32) {
+	r.flow.pause(topic, partitions)
+}
+
+func (r *reader) Resume(topic string, partitions ...int32) {
+	r.flow.resume(topic, partitions)
+}
+
+func (r *reader) markClosed() bool {
+	return atomic.CompareAndSwapInt32(&r.closed, 0, 1)
+}
+
+// beginHandle counts a handler in-flight, false once the reader is closed.
+func (r *reader) beginHandle() bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.isClosed() {
+		return false
+	}
+	r.handlers.Add(1)
+	return true
+}
+
+// idle returns a channel closed once the in-flight handlers returned.
+func (r *reader) idle() <-chan struct{} {
+	done := make(chan struct{})
+	go func() {
+		r.handlers.Wait()
+		close(done)
+	}()
+	return done
 }
 
 func (r *reader) isClosed() bool {
 	return atomic.LoadInt32(&r.closed) != 0
 }
 
+// Close stops consuming and waits up to DrainTimeout for the in-flight
+// handlers to return, the session then ends and commits their offsets before
+// the reader leaves the group.
 func (r *reader) Close() error {
-	if r.isClosed() {
+	r.mutex.Lock()
+	closed := r.markClosed()
+	r.mutex.Unlock()
+	if !closed {
 		return nil
 	}
 
-	r.Cancel()
 	close(r.close)
-	r.markClosed()
+	if r.opts.DrainTimeout > 0 {
+		deadline := time.Now().Add(r.opts.DrainTimeout)
+		if !drain(r.idle(), r.opts.DrainTimeout) {
+			r.opts.Logger.Warnf("drain timeout after %s, closing with in-flight handlers", r.opts.DrainTimeout)
+		}
+		// A session without claims only ends once its context is cancelled.
+		r.Cancel()
+		drain(r.consumed, time.Until(deadline))
+	}
+	r.flow.stop()
+	r.Cancel()
 	err := r.consumer.Close()
+	r.unregisterLogs()
 	if err != nil {
 		return err
 	}
@@ -148,15 +247,34 @@ func (r *reader) Close() error {
 	return nil
 }
 
+// drain waits up to timeout for done to be closed.
+func drain(done <-chan struct{}, timeout time.Duration) bool {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-done:
+		return true
+	case <-timer.C:
+		return false
+	}
+}
+
 // Setup is run at the beginning of a new session, before ConsumeClaim
 func (r *reader) Setup(session sarama.ConsumerGroupSession) error {
-	r.opts.Logger.Debugf("Consume Setup GenerationID: %d, MemberID: %s", session.GenerationID(), session.MemberID())
+	r.opts.Logger.Debugf("Consume Setup GenerationID: %d, MemberID: %s, Claims: %v", session.GenerationID(), session.MemberID(), session.Claims())
+	r.flow.assign(session.Claims())
+	if r.opts.OnAssigned != nil {
+		return r.opts.OnAssigned(session, session.Claims())
+	}
 	return nil
 }
 
 // Cleanup is run at the end of a session, once all ConsumeClaim goroutines have exited
 func (r *reader) Cleanup(session sarama.ConsumerGroupSession) error {
-	r.opts.Logger.Debugf("Consume Cleanup GenerationID: %d, MemberID: %s", session.GenerationID(), session.MemberID())
+	r.opts.Logger.Debugf("Consume Cleanup GenerationID: %d, MemberID: %s, Claims: %v", session.GenerationID(), session.MemberID(), session.Claims())
+	if r.opts.OnRevoked != nil {
+		return r.opts.OnRevoked(session, session.Claims())
+	}
 	return nil
 }
 
@@ -166,6 +284,7 @@ func (r *reader) ConsumeClaim(session sarama.ConsumerGroupSession, claim sarama.
 	// Do not move the code below to a goroutine.
 	// The `ConsumeClaim` itself is called within a goroutine, see:
 	// https://github.com/Shopify/sarama/blob/master/consumer_group.go#L27-L29
+	r.flow.claim(claim.Topic(), claim.Partition())
 	for {
 		select {
 		case <-r.close:
@@ -173,15 +292,19 @@ func (r *reader) ConsumeClaim(session sarama.ConsumerGroupSession, claim sarama.
 		case <-session.Context().Done():
 			return nil
 		case message, ok := <-claim.Messages():
-			if !ok {
+			if !ok || r.isClosed() {
 				return nil
 			}
 			if r.handler == nil {
 				r.opts.Logger.Error("ignore, unregistered handler")
 				continue
 			}
+			if !r.beginHandle() {
+				return nil
+			}
 
 			err := r.Handler(message, session, claim)
+			r.handlers.Done()
 			if err != nil {
 				logger.Error(err)
 			}
@@ -191,6 +314,11 @@ func (r *reader) ConsumeClaim(session sarama.ConsumerGroupSession, claim sarama.
 
 // Handler handler message
 func (r *reader) Handler(msg *sarama.ConsumerMessage, session sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
+	return r.handle(msg, session, claim, r.handler)
+}
+
+// handle traces and instruments handler with msg.
+func (r *reader) handle(msg *sarama.ConsumerMessage, session sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim, handler Handler) error {
 	var (
 		span trace.Span
 	)
@@ -208,7 +336,12 @@ func (r *reader) Handler(msg *sarama.ConsumerMessage, session sarama.ConsumerGro
 	))
 	defer span.End()
 
-	err := r.handler(ctx, session, decodeConsumerMessage(msg))
+	message := decodeConsumerMessage(msg)
+	r.flow.begin()
+	start := time.Now()
+	err := handler(ctx, session, message)
+	r.flow.end(err)
+	r.opts.Metrics.observeHandle(message, claim.HighWaterMarkOffset(), time.Since(start), err)
 	if err != nil {
 		span.RecordError(err)
 		span.SetStatus(codes.Error, err.Error())
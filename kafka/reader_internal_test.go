@@ -0,0 +1,268 @@
+package kafka
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/stretchr/testify/assert"
+)
+
+// stubConsumerGroup runs a single session claiming partition 0 of the topic,
+// or nothing when unclaimed, ending like sarama once a ConsumeClaim returned
+// or the context is cancelled, and records the calls made to it and to its
+// session.
+type stubConsumerGroup struct {
+	sarama.ConsumerGroup
+
+	unclaimed bool
+
+	messages chan *sarama.ConsumerMessage
+	errors   chan error
+
+	mutex     sync.Mutex
+	events    []string
+	marked    int64
+	committed int64
+}
+
+func newStubConsumerGroup(unclaimed bool) *stubConsumerGroup {
+	return &stubConsumerGroup{
+		unclaimed: unclaimed,
+		messages:  make(chan *sarama.ConsumerMessage, 16),
+		errors:    make(chan error),
+		marked:    -1,
+		committed: -1,
+	}
+}
+
+func (g *stubConsumerGroup) record(event string) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	g.events = append(g.events, event)
+}
+
+func (g *stubConsumerGroup) Events() []string {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	return append([]string(nil), g.events...)
+}
+
+func (g *stubConsumerGroup) Committed() int64 {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	return g.committed
+}
+
+func (g *stubConsumerGroup) Consume(ctx context.Context, topics []string, handler sarama.ConsumerGroupHandler) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	claims := map[string][]int32{topics[0]: {0}}
+	if g.unclaimed {
+		claims = map[string][]int32{}
+	}
+	session := &stubSession{group: g, ctx: ctx, claims: claims}
+	if err := handler.Setup(session); err != nil {
+		return err
+	}
+
+	if g.unclaimed {
+		<-ctx.Done()
+	} else {
+		g.consumeClaim(ctx, session, topics[0], handler)
+	}
+
+	err := handler.Cleanup(session)
+	g.mutex.Lock()
+	g.events = append(g.events, "commit")
+	g.committed = g.marked
+	g.mutex.Unlock()
+	return err
+}
+
+// consumeClaim forwards the messages to the claim of partition 0 until it
+// returned, or the context is cancelled and it returned.
+func (g *stubConsumerGroup) consumeClaim(ctx context.Context, session *stubSession, topic string, handler sarama.ConsumerGroupHandler) {
+	claim := &stubClaim{topic: topic, messages: make(chan *sarama.ConsumerMessage)}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_ = handler.ConsumeClaim(session, claim)
+	}()
+forward:
+	for {
+		select {
+		case <-done:
+			break forward
+		case <-ctx.Done():
+			break forward
+		case message := <-g.messages:
+			select {
+			case claim.messages <- message:
+			case <-done:
+				break forward
+			}
+		}
+	}
+	close(claim.messages)
+	<-done
+}
+
+func (g *stubConsumerGroup) Errors() <-chan error { return g.errors }
+
+func (g *stubConsumerGroup) Close() error {
+	g.record("close")
+	close(g.errors)
+	return nil
+}
+
+func (g *stubConsumerGroup) Pause(partitions map[string][]int32) {}
+
+func (g *stubConsumerGroup) Resume(partitions map[string][]int32) {}
+
+func (g *stubConsumerGroup) PauseAll() {}
+
+func (g *stubConsumerGroup) ResumeAll() {}
+
+type stubSession struct {
+	group  *stubConsumerGroup
+	ctx    context.Context
+	claims map[string][]int32
+}
+
+func (s *stubSession) Claims() map[string][]int32 { return s.claims }
+
+func (s *stubSession) MemberID() string { return "member" }
+
+func (s *stubSession) GenerationID() int32 { return 1 }
+
+func (s *stubSession) MarkOffset(topic string, partition int32, offset int64, metadata string) {
+	s.group.mutex.Lock()
+	defer s.group.mutex.Unlock()
+	s.group.marked = offset
+}
+
+func (s *stubSession) Commit() {}
+
+func (s *stubSession) ResetOffset(topic string, partition int32, offset int64, metadata string) {}
+
+func (s *stubSession) MarkMessage(msg *sarama.ConsumerMessage, metadata string) {
+	s.MarkOffset(msg.Topic, msg.Partition, msg.Offset+1, metadata)
+}
+
+func (s *stubSession) Context() context.Context { return s.ctx }
+
+type stubClaim struct {
+	topic    string
+	messages chan *sarama.ConsumerMessage
+}
+
+func (c *stubClaim) Topic() string { return c.topic }
+
+func (c *stubClaim) Partition() int32 { return 0 }
+
+func (c *stubClaim) InitialOffset() int64 { return 0 }
+
+func (c *stubClaim) HighWaterMarkOffset() int64 { return 0 }
+
+func (c *stubClaim) Messages() <-chan *sarama.ConsumerMessage { return c.messages }
+
+// newStubReader starts a reader consuming from a stubConsumerGroup.
+func newStubReader(unclaimed bool, opts ...ReaderOpt) (*reader, *stubConsumerGroup) {
+	r := &reader{
+		opts:           newReaderOptions(nil, "t", "g", opts...),
+		close:          make(chan bool),
+		consumed:       make(chan struct{}),
+		unregisterLogs: func() {},
+	}
+	r.flow = newFlowControl(r.opts.Backpressure, r.opts.Logger)
+	g := newStubConsumerGroup(unclaimed)
+	r.start(g)
+	return r, g
+}
+
+func TestReader_CloseDrainsSession(t *testing.T) {
+	var hooks []string
+	r, g := newStubReader(false,
+		ReaderOnAssigned(func(session sarama.ConsumerGroupSession, partitions map[string][]int32) error {
+			hooks = append(hooks, fmt.Sprintf("assigned %v", partitions))
+			return nil
+		}),
+		ReaderOnRevoked(func(session sarama.ConsumerGroupSession, partitions map[string][]int32) error {
+			hooks = append(hooks, fmt.Sprintf("revoked %v", partitions))
+			return nil
+		}),
+	)
+
+	started, release := make(chan struct{}), make(chan struct{})
+	_ = r.FetchMessage(context.Background(), func(ctx context.Context, session sarama.ConsumerGroupSession, message *ConsumerMessage) error {
+		close(started)
+		<-release
+		return r.CommitMessage(ctx, session, message)
+	})
+	g.messages <- &sarama.ConsumerMessage{Topic: "t", Offset: 41, Value: []byte("a")}
+	<-started
+
+	closed := make(chan struct{})
+	go func() {
+		assert.Nil(t, r.Close())
+		close(closed)
+	}()
+	select {
+	case <-closed:
+		t.Fatal("Close() returned with an in-flight handler")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(release)
+	<-closed
+
+	// The session ends and commits the offset of the handler before the
+	// reader leaves the group.
+	assert.Equal(t, []string{"commit", "close"}, g.Events())
+	assert.Equal(t, int64(42), g.Committed())
+	assert.Equal(t, []string{"assigned map[t:[0]]", "revoked map[t:[0]]"}, hooks)
+}
+
+func TestReader_CloseDrainTimeout(t *testing.T) {
+	r, g := newStubReader(false, ReaderDrainTimeout(50*time.Millisecond))
+
+	started, release := make(chan struct{}), make(chan struct{})
+	defer close(release)
+	_ = r.FetchMessage(context.Background(), func(ctx context.Context, session sarama.ConsumerGroupSession, message *ConsumerMessage) error {
+		close(started)
+		<-release
+		return r.CommitMessage(ctx, session, message)
+	})
+	g.messages <- &sarama.ConsumerMessage{Topic: "t", Offset: 41, Value: []byte("a")}
+	<-started
+
+	start := time.Now()
+	assert.Nil(t, r.Close())
+	assert.Less(t, time.Since(start), time.Second)
+	// The reader left the group without waiting for the session.
+	assert.Equal(t, []string{"close"}, g.Events())
+	assert.Equal(t, int64(-1), g.Committed())
+}
+
+func TestReader_CloseWithoutClaims(t *testing.T) {
+	log := &recordingLogger{}
+	r, g := newStubReader(true, ReaderLogger(log))
+	_ = r.FetchMessage(context.Background(), func(ctx context.Context, session sarama.ConsumerGroupSession, message *ConsumerMessage) error {
+		return nil
+	})
+
+	start := time.Now()
+	assert.Nil(t, r.Close())
+	assert.Less(t, time.Since(start), time.Second)
+	// The session without claims ends once cancelled instead of waiting
+	// for the drain timeout.
+	assert.Equal(t, []string{"commit", "close"}, g.Events())
+	log.mutex.Lock()
+	defer log.mutex.Unlock()
+	for _, line := range log.lines {
+		assert.NotContains(t, line, "drain timeout")
+	}
+}
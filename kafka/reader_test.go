@@ -58,3 +58,98 @@ func HandlerMsgBiz(ctx context.Context, message *ConsumerMessage) {
 	fmt.Println("===========================>", string(message.Value))
 	fmt.Println("trace:", tracex.ExtractTraceId(ctx))
 }
+
+func TestNewConsumerConfig(t *testing.T) {
+	opts := newReaderOptions([]string{addr}, topic, group,
+		ReaderVersion("2.8.1"),
+		ReaderRebalanceStrategy(RebalanceSticky),
+		ReaderFetch(1, 1<<20, 10<<20),
+		ReaderSessionTimeout(30*time.Second),
+		ReaderHeartbeatInterval(5*time.Second),
+		ReaderIsolationLevel(ReadCommitted),
+		ReaderSaramaConfig(func(config *sarama.Config) {
+			config.ChannelBufferSize = 16
+		}),
+	)
+	config, err := newConsumerConfig(opts)
+	assert.Nil(t, err)
+	assert.Nil(t, config.Validate())
+	assert.Equal(t, sarama.V2_8_1_0, config.Version)
+	assert.Equal(t, sarama.BalanceStrategySticky, config.Consumer.Group.Rebalance.Strategy)
+	assert.Equal(t, int32(1<<20), config.Consumer.Fetch.Default)
+	assert.Equal(t, 30*time.Second, config.Consumer.Group.Session.Timeout)
+	assert.Equal(t, sarama.ReadCommitted, config.Consumer.IsolationLevel)
+	assert.Equal(t, 16, config.ChannelBufferSize)
+
+	_, err = newConsumerConfig(newReaderOptions([]string{addr}, topic, group, ReaderVersion("x.y")))
+	assert.NotNil(t, err)
+}
+
+func TestReader_DrainOnClose(t *testing.T) {
+	broker := NewMockBroker(2)
+	w := broker.NewWriter(WriterPartitioner(ManualPartitioner))
+	defer w.Close()
+
+	var (
+		assigned, revoked []map[string][]int32
+		started           = make(chan struct{})
+		release           = make(chan struct{})
+	)
+	r := broker.NewReader(topic, group,
+		ReaderOnAssigned(func(session sarama.ConsumerGroupSession, partitions map[string][]int32) error {
+			assigned = append(assigned, partitions)
+			return nil
+		}),
+		ReaderOnRevoked(func(session sarama.ConsumerGroupSession, partitions map[string][]int32) error {
+			revoked = append(revoked, partitions)
+			return nil
+		}),
+	)
+	_ = r.FetchMessage(context.Background(), func(ctx context.Context, session sarama.ConsumerGroupSession, message *ConsumerMessage) error {
+		close(started)
+		<-release
+		return r.CommitMessage(ctx, session, message)
+	})
+	_ = w.SendMessage(context.Background(), &ProducerMessage{Topic: topic, Partition: 1, Value: []byte("a")})
+	<-started
+
+	closed := make(chan struct{})
+	go func() {
+		_ = r.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+		t.Fatal("Close() returned with an in-flight handler")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(release)
+	<-closed
+
+	assert.Equal(t, int64(1), broker.Committed(group, topic, 1))
+	assert.Equal(t, []map[string][]int32{{topic: {0, 1}}}, assigned)
+	assert.Equal(t, []map[string][]int32{{topic: {0, 1}}}, revoked)
+}
+
+func TestReader_DrainTimeout(t *testing.T) {
+	broker := NewMockBroker(1)
+	w := broker.NewWriter()
+	defer w.Close()
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	defer close(release)
+	r := broker.NewReader(topic, group, ReaderDrainTimeout(50*time.Millisecond))
+	_ = r.FetchMessage(context.Background(), func(ctx context.Context, session sarama.ConsumerGroupSession, message *ConsumerMessage) error {
+		close(started)
+		<-release
+		return nil
+	})
+	_ = w.SendMessage(context.Background(), &ProducerMessage{Topic: topic, Value: []byte("a")})
+	<-started
+
+	start := time.Now()
+	assert.Nil(t, r.Close())
+	assert.Less(t, time.Since(start), time.Second)
+	assert.Equal(t, int64(-1), broker.Committed(group, topic, 0))
+}
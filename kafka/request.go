@@ -0,0 +1,237 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/LabKiko/kiko-gokit/logger"
+	"github.com/Shopify/sarama"
+	uuid "github.com/satori/go.uuid"
+)
+
+const (
+	// CorrelationIDHeader is the header matching a reply to its request.
+	CorrelationIDHeader = "correlation-id"
+	// ReplyToHeader is the header carrying the topic the reply of a request
+	// is sent to.
+	ReplyToHeader = "reply-to"
+	// ReplyErrorHeader is the header carrying the error of a failed request.
+	ReplyErrorHeader = "reply-error"
+
+	defaultRequestTimeout = 30 * time.Second
+)
+
+// ErrRequesterClosed is returned by the requests pending or made once the
+// Requester is closed.
+var ErrRequesterClosed = errors.New("kafka: requester closed")
+
+// ReplyError is returned by Requester.Request when the responder failed to
+// handle the request.
+type ReplyError struct {
+	Message string
+	Reply   *ConsumerMessage
+}
+
+func (e *ReplyError) Error() string {
+	return "kafka: reply error: " + e.Message
+}
+
+type RequesterOpts struct {
+	// Timeout bounds the requests whose context has no deadline, default 30s.
+	Timeout time.Duration
+
+	Logger logger.Logger
+}
+
+type RequesterOpt func(o *RequesterOpts)
+
+func newRequesterOptions(opts ...RequesterOpt) RequesterOpts {
+	opt := RequesterOpts{
+		Timeout: defaultRequestTimeout,
+	}
+	for _, o := range opts {
+		o(&opt)
+	}
+	if opt.Logger == nil {
+		opt.Logger = logger.New(
+			logger.WithNamespace("kafka"),
+			logger.WithConsole(true),
+			logger.WithDisableDisk(true),
+			logger.WithFields(map[string]interface{}{
+				"event": "request",
+			}),
+		)
+	}
+	return opt
+}
+
+// RequesterTimeout sets the timeout of the requests whose context has no deadline.
+func RequesterTimeout(timeout time.Duration) RequesterOpt {
+	return func(o *RequesterOpts) {
+		o.Timeout = timeout
+	}
+}
+
+func RequesterLogger(logger logger.Logger) RequesterOpt {
+	return func(o *RequesterOpts) {
+		o.Logger = logger
+	}
+}
+
+// Requester sends requests and waits for their replies on a reply topic
+// owned by the instance, the replies are matched by CorrelationIDHeader.
+// The replies received after their request timed out are dropped.
+type Requester struct {
+	writer     Writer
+	reader     Reader
+	replyTopic string
+	opts       RequesterOpts
+
+	mutex   sync.Mutex
+	pending map[string]chan *ConsumerMessage
+	closing chan struct{}
+	closed  bool
+}
+
+// NewRequester sends the requests with w and consumes their replies from
+// replyTopic with r, which must read replyTopic and nothing else. The reply
+// topic should be unique to the instance, e.g. suffixed by the hostname,
+// and r start from OffsetNewest.
+func NewRequester(w Writer, r Reader, replyTopic string, opts ...RequesterOpt) (*Requester, error) {
+	q := &Requester{
+		writer:     w,
+		reader:     r,
+		replyTopic: replyTopic,
+		opts:       newRequesterOptions(opts...),
+		pending:    make(map[string]chan *ConsumerMessage),
+		closing:    make(chan struct{}),
+	}
+	if err := r.FetchMessage(context.Background(), q.handle); err != nil {
+		return nil, err
+	}
+	return q, nil
+}
+
+// Request sends message and returns its reply. It fails when ctx is done,
+// or after the requester timeout if ctx has no deadline, and with a
+// *ReplyError when the responder returned an error.
+func (q *Requester) Request(ctx context.Context, message *ProducerMessage) (*ConsumerMessage, error) {
+	if _, ok := ctx.Deadline(); !ok && q.opts.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, q.opts.Timeout)
+		defer cancel()
+	}
+
+	id := uuid.NewV4().String()
+	replies := make(chan *ConsumerMessage, 1)
+	q.mutex.Lock()
+	if q.closed {
+		q.mutex.Unlock()
+		return nil, ErrRequesterClosed
+	}
+	q.pending[id] = replies
+	q.mutex.Unlock()
+	defer func() {
+		q.mutex.Lock()
+		delete(q.pending, id)
+		q.mutex.Unlock()
+	}()
+
+	message.SetHeader(CorrelationIDHeader, id)
+	message.SetHeader(ReplyToHeader, q.replyTopic)
+	if err := q.writer.SendMessage(ctx, message); err != nil {
+		return nil, err
+	}
+
+	select {
+	case reply := <-replies:
+		if msg := reply.Header(ReplyErrorHeader); msg != "" {
+			return nil, &ReplyError{Message: msg, Reply: reply}
+		}
+		return reply, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-q.closing:
+		return nil, ErrRequesterClosed
+	}
+}
+
+// Close fails the pending requests and closes the reader, the writer is not
+// closed.
+func (q *Requester) Close() error {
+	q.mutex.Lock()
+	if q.closed {
+		q.mutex.Unlock()
+		return nil
+	}
+	q.closed = true
+	q.mutex.Unlock()
+
+	close(q.closing)
+	return q.reader.Close()
+}
+
+func (q *Requester) handle(ctx context.Context, session sarama.ConsumerGroupSession, message *ConsumerMessage) error {
+	id := message.Header(CorrelationIDHeader)
+	q.mutex.Lock()
+	replies, ok := q.pending[id]
+	q.mutex.Unlock()
+	if ok {
+		// The channel is buffered and only one reply is expected.
+		select {
+		case replies <- message:
+		default:
+		}
+	} else {
+		q.opts.Logger.Debugf("drop reply %s at %s/%d/%d without pending request", id, message.Topic, message.Partition, message.Offset)
+	}
+	return q.reader.CommitMessage(ctx, session, message)
+}
+
+// ReplyFunc handles a request and returns its reply, whose topic is set by
+// the Responder.
+type ReplyFunc func(ctx context.Context, request *ConsumerMessage) (*ProducerMessage, error)
+
+// Responder replies to the requests sent by a Requester.
+type Responder struct {
+	writer Writer
+}
+
+// NewResponder sends the replies with w.
+func NewResponder(w Writer) *Responder {
+	return &Responder{writer: w}
+}
+
+// Reply sends reply to the requester of request. The requests without
+// ReplyToHeader expect no reply and are ignored.
+func (r *Responder) Reply(ctx context.Context, request *ConsumerMessage, reply *ProducerMessage) error {
+	replyTo := request.Header(ReplyToHeader)
+	if replyTo == "" {
+		return nil
+	}
+	reply.Topic = replyTo
+	reply.SetHeader(CorrelationIDHeader, request.Header(CorrelationIDHeader))
+	return r.writer.SendMessage(ctx, reply)
+}
+
+// Handler returns a Handler replying to each request with the result of fn,
+// an error being returned to the requester as a *ReplyError. The request is
+// marked as consumed once replied.
+func (r *Responder) Handler(fn ReplyFunc) Handler {
+	return func(ctx context.Context, session sarama.ConsumerGroupSession, message *ConsumerMessage) error {
+		reply, err := fn(ctx, message)
+		if err != nil {
+			reply = &ProducerMessage{}
+			reply.SetHeader(ReplyErrorHeader, err.Error())
+		} else if reply == nil {
+			reply = &ProducerMessage{}
+		}
+		if err := r.Reply(ctx, message, reply); err != nil {
+			return err
+		}
+		session.MarkMessage(encodedConsumerMessage(message), "")
+		return nil
+	}
+}
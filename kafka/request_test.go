@@ -0,0 +1,54 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRequester(t *testing.T) {
+	broker := NewMockBroker(1)
+	w := broker.NewWriter()
+	defer w.Close()
+
+	service := broker.NewReader("requests", "service")
+	defer service.Close()
+	responder := NewResponder(w)
+	_ = service.FetchMessage(context.Background(), responder.Handler(func(ctx context.Context, request *ConsumerMessage) (*ProducerMessage, error) {
+		switch string(request.Value) {
+		case "fail":
+			return nil, errors.New("bad request")
+		case "slow":
+			time.Sleep(50 * time.Millisecond)
+		}
+		return &ProducerMessage{Value: []byte(strings.ToUpper(string(request.Value)))}, nil
+	}))
+
+	q, err := NewRequester(w, broker.NewReader("replies.instance-1", "instance-1"), "replies.instance-1", RequesterTimeout(time.Second))
+	assert.Nil(t, err)
+
+	reply, err := q.Request(context.Background(), &ProducerMessage{Topic: "requests", Value: []byte("ping")})
+	assert.Nil(t, err)
+	assert.Equal(t, "PING", string(reply.Value))
+
+	_, err = q.Request(context.Background(), &ProducerMessage{Topic: "requests", Value: []byte("fail")})
+	var replyErr *ReplyError
+	assert.True(t, errors.As(err, &replyErr))
+	assert.Equal(t, "bad request", replyErr.Message)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err = q.Request(ctx, &ProducerMessage{Topic: "requests", Value: []byte("slow")})
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	// The late reply is dropped.
+	waitIdle(t, broker)
+	assert.Len(t, broker.Messages("replies.instance-1"), 3)
+
+	assert.Nil(t, q.Close())
+	_, err = q.Request(context.Background(), &ProducerMessage{Topic: "requests", Value: []byte("ping")})
+	assert.ErrorIs(t, err, ErrRequesterClosed)
+}
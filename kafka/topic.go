@@ -0,0 +1,220 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+
+	"github.com/Shopify/sarama"
+	"go.uber.org/multierr"
+)
+
+// TopicSpec is the desired state of a topic.
+type TopicSpec struct {
+	Name string
+	// Partitions is the number of partitions, the num.partitions of the
+	// controller when zero. The partitions of an existing topic can only be
+	// increased.
+	Partitions int32
+	// ReplicationFactor is the number of replicas of each partition, the
+	// default.replication.factor of the controller when zero. It is never
+	// changed on an existing topic.
+	ReplicationFactor int16
+	// Configs are the topic configs to enforce, e.g. "retention.ms" or
+	// "cleanup.policy", the other configs are left untouched.
+	Configs map[string]string
+}
+
+// TopicDiff is the difference between a TopicSpec and the actual topic.
+type TopicDiff struct {
+	Name string
+	// Missing is set when the topic does not exist, the other fields are
+	// then left empty.
+	Missing bool
+	// Partitions and ReplicationFactor are the actual values, set when they
+	// differ from the desired ones.
+	Partitions        int32
+	ReplicationFactor int16
+	// Configs maps the configs whose value differs to their actual value,
+	// "" when the broker default applies.
+	Configs map[string]string
+}
+
+// Empty reports whether the topic matches its spec.
+func (d *TopicDiff) Empty() bool {
+	return !d.Missing && d.Partitions == 0 && d.ReplicationFactor == 0 && len(d.Configs) == 0
+}
+
+func (a *admin) DiffTopics(specs ...TopicSpec) ([]*TopicDiff, error) {
+	topics, err := a.admin.ListTopics()
+	if err != nil {
+		return nil, err
+	}
+
+	diffs := make([]*TopicDiff, 0)
+	for _, spec := range specs {
+		if diff := diffTopic(spec, topics); !diff.Empty() {
+			diffs = append(diffs, diff)
+		}
+	}
+	return diffs, nil
+}
+
+// Decreasing the partitions or changing the replication factor requires a
+// reassignment, it is reported as an error.
+func (a *admin) EnsureTopics(specs ...TopicSpec) error {
+	diffs, err := a.DiffTopics(specs...)
+	if err != nil {
+		return err
+	}
+
+	byName := make(map[string]TopicSpec, len(specs))
+	for _, spec := range specs {
+		byName[spec.Name] = spec
+	}
+	for _, diff := range diffs {
+		err = multierr.Append(err, a.ensureTopic(byName[diff.Name], diff))
+	}
+	return err
+}
+
+func (a *admin) ensureTopic(spec TopicSpec, diff *TopicDiff) (err error) {
+	if diff.Missing {
+		detail := &sarama.TopicDetail{
+			NumPartitions:     spec.Partitions,
+			ReplicationFactor: spec.ReplicationFactor,
+			ConfigEntries:     make(map[string]*string, len(spec.Configs)),
+		}
+		if detail.NumPartitions <= 0 || detail.ReplicationFactor <= 0 {
+			partitions, replicationFactor, err := a.topicDefaults()
+			if err != nil {
+				return fmt.Errorf("kafka: create topic %s: %w", spec.Name, err)
+			}
+			if detail.NumPartitions <= 0 {
+				detail.NumPartitions = partitions
+			}
+			if detail.ReplicationFactor <= 0 {
+				detail.ReplicationFactor = replicationFactor
+			}
+		}
+		for k, v := range spec.Configs {
+			v := v
+			detail.ConfigEntries[k] = &v
+		}
+		// The topic may have been created concurrently.
+		if err := a.admin.CreateTopic(spec.Name, detail, false); err != nil && !errors.Is(err, sarama.ErrTopicAlreadyExists) {
+			return fmt.Errorf("kafka: create topic %s: %w", spec.Name, err)
+		}
+		return nil
+	}
+
+	if diff.Partitions > 0 {
+		if diff.Partitions > spec.Partitions {
+			err = multierr.Append(err, fmt.Errorf("kafka: topic %s has %d partitions, cannot decrease to %d", spec.Name, diff.Partitions, spec.Partitions))
+		} else if e := a.admin.CreatePartitions(spec.Name, spec.Partitions, nil, false); e != nil {
+			err = multierr.Append(err, fmt.Errorf("kafka: add partitions to topic %s: %w", spec.Name, e))
+		}
+	}
+	if diff.ReplicationFactor > 0 {
+		err = multierr.Append(err, fmt.Errorf("kafka: topic %s has replication factor %d, not %d", spec.Name, diff.ReplicationFactor, spec.ReplicationFactor))
+	}
+	if len(diff.Configs) > 0 {
+		entries := make(map[string]sarama.IncrementalAlterConfigsEntry, len(diff.Configs))
+		for k := range diff.Configs {
+			v := spec.Configs[k]
+			entries[k] = sarama.IncrementalAlterConfigsEntry{Operation: sarama.IncrementalAlterConfigsOperationSet, Value: &v}
+		}
+		if e := a.admin.IncrementalAlterConfig(sarama.TopicResource, spec.Name, entries, false); e != nil {
+			err = multierr.Append(err, fmt.Errorf("kafka: alter configs of topic %s: %w", spec.Name, e))
+		}
+	}
+	return err
+}
+
+// topicDefaults returns the num.partitions and default.replication.factor of
+// the controller. sarama sends CreateTopics requests older than v4, whose
+// brokers reject the -1 standing for their defaults.
+func (a *admin) topicDefaults() (partitions int32, replicationFactor int16, err error) {
+	_, controller, err := a.admin.DescribeCluster()
+	if err != nil {
+		return 0, 0, err
+	}
+	entries, err := a.admin.DescribeConfig(sarama.ConfigResource{
+		Type:        sarama.BrokerResource,
+		Name:        strconv.Itoa(int(controller)),
+		ConfigNames: []string{"num.partitions", "default.replication.factor"},
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+
+	for _, entry := range entries {
+		value, err := strconv.ParseInt(entry.Value, 10, 32)
+		if err != nil {
+			return 0, 0, fmt.Errorf("broker config %s: %w", entry.Name, err)
+		}
+		switch entry.Name {
+		case "num.partitions":
+			partitions = int32(value)
+		case "default.replication.factor":
+			replicationFactor = int16(value)
+		}
+	}
+	if partitions <= 0 || replicationFactor <= 0 {
+		return 0, 0, errors.New("broker default partitions or replication factor not found")
+	}
+	return partitions, replicationFactor, nil
+}
+
+func diffTopic(spec TopicSpec, topics map[string]sarama.TopicDetail) *TopicDiff {
+	diff := &TopicDiff{Name: spec.Name}
+	detail, ok := topics[spec.Name]
+	if !ok {
+		diff.Missing = true
+		return diff
+	}
+
+	if spec.Partitions > 0 && detail.NumPartitions != spec.Partitions {
+		diff.Partitions = detail.NumPartitions
+	}
+	if spec.ReplicationFactor > 0 && detail.ReplicationFactor != spec.ReplicationFactor {
+		diff.ReplicationFactor = detail.ReplicationFactor
+	}
+	for k, v := range spec.Configs {
+		var actual string
+		// The default configs are not listed.
+		if v := detail.ConfigEntries[k]; v != nil {
+			actual = *v
+		}
+		if actual != v {
+			if diff.Configs == nil {
+				diff.Configs = make(map[string]string)
+			}
+			diff.Configs[k] = actual
+		}
+	}
+	return diff
+}
+
+// AutoCreateTopics ensures the topic of each message exists before its
+// first send, creating it from spec with the topic name. The topics are
+// checked once per writer, a failure fails the send and is retried on the
+// next one.
+func AutoCreateTopics(admin Admin, spec TopicSpec) Interceptor {
+	var ensured sync.Map
+	return func(next SendFunc) SendFunc {
+		return func(ctx context.Context, message *ProducerMessage) error {
+			if _, ok := ensured.Load(message.Topic); !ok {
+				topic := spec
+				topic.Name = message.Topic
+				if err := admin.EnsureTopics(topic); err != nil {
+					return err
+				}
+				ensured.Store(message.Topic, struct{}{})
+			}
+			return next(ctx, message)
+		}
+	}
+}
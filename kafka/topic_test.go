@@ -0,0 +1,147 @@
+package kafka
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/Shopify/sarama"
+	"github.com/stretchr/testify/assert"
+)
+
+// topicClusterAdmin keeps the topics in memory, the other methods panic.
+// It rejects the topics created without explicit partitions and
+// replication factor as the brokers do below CreateTopics v4.
+type topicClusterAdmin struct {
+	sarama.ClusterAdmin
+
+	mutex    sync.Mutex
+	topics   map[string]sarama.TopicDetail
+	creates  int
+	defaults []sarama.ConfigEntry // configs of the controller
+}
+
+func (a *topicClusterAdmin) DescribeCluster() ([]*sarama.Broker, int32, error) {
+	return nil, 1, nil
+}
+
+func (a *topicClusterAdmin) DescribeConfig(resource sarama.ConfigResource) ([]sarama.ConfigEntry, error) {
+	if resource.Type != sarama.BrokerResource || resource.Name != "1" {
+		return nil, sarama.ErrUnknownTopicOrPartition
+	}
+	return a.defaults, nil
+}
+
+func (a *topicClusterAdmin) ListTopics() (map[string]sarama.TopicDetail, error) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	topics := make(map[string]sarama.TopicDetail, len(a.topics))
+	for name, detail := range a.topics {
+		topics[name] = detail
+	}
+	return topics, nil
+}
+
+func (a *topicClusterAdmin) CreateTopic(topic string, detail *sarama.TopicDetail, validateOnly bool) error {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	if detail.NumPartitions <= 0 {
+		return sarama.ErrInvalidPartitions
+	}
+	if detail.ReplicationFactor <= 0 {
+		return sarama.ErrInvalidReplicationFactor
+	}
+	a.creates++
+	a.topics[topic] = *detail
+	return nil
+}
+
+func (a *topicClusterAdmin) CreatePartitions(topic string, count int32, assignment [][]int32, validateOnly bool) error {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	detail := a.topics[topic]
+	detail.NumPartitions = count
+	a.topics[topic] = detail
+	return nil
+}
+
+func (a *topicClusterAdmin) IncrementalAlterConfig(resourceType sarama.ConfigResourceType, name string, entries map[string]sarama.IncrementalAlterConfigsEntry, validateOnly bool) error {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	detail := a.topics[name]
+	configs := make(map[string]*string, len(detail.ConfigEntries)+len(entries))
+	for k, v := range detail.ConfigEntries {
+		configs[k] = v
+	}
+	for k, entry := range entries {
+		configs[k] = entry.Value
+	}
+	detail.ConfigEntries = configs
+	a.topics[name] = detail
+	return nil
+}
+
+func TestAdmin_EnsureTopics(t *testing.T) {
+	day := "86400000"
+	cluster := &topicClusterAdmin{topics: map[string]sarama.TopicDetail{
+		"orders": {NumPartitions: 6, ReplicationFactor: 2, ConfigEntries: map[string]*string{"retention.ms": &day}},
+	}}
+	a := &admin{admin: cluster}
+
+	specs := []TopicSpec{
+		{Name: "events", Partitions: 3, ReplicationFactor: 3, Configs: map[string]string{"cleanup.policy": "compact"}},
+		{Name: "orders", Partitions: 12, ReplicationFactor: 3, Configs: map[string]string{"retention.ms": "604800000", "cleanup.policy": "delete"}},
+	}
+	diffs, err := a.DiffTopics(specs...)
+	assert.Nil(t, err)
+	assert.Equal(t, []*TopicDiff{
+		{Name: "events", Missing: true},
+		{Name: "orders", Partitions: 6, ReplicationFactor: 2, Configs: map[string]string{"retention.ms": day, "cleanup.policy": ""}},
+	}, diffs)
+
+	// The replication factor of an existing topic is not changed.
+	err = a.EnsureTopics(specs...)
+	assert.ErrorContains(t, err, "replication factor 2")
+	diffs, _ = a.DiffTopics(specs...)
+	assert.Equal(t, []*TopicDiff{{Name: "orders", ReplicationFactor: 2}}, diffs)
+	assert.Equal(t, int32(3), cluster.topics["events"].NumPartitions)
+	assert.Equal(t, "compact", *cluster.topics["events"].ConfigEntries["cleanup.policy"])
+
+	err = a.EnsureTopics(TopicSpec{Name: "orders", Partitions: 4})
+	assert.ErrorContains(t, err, "cannot decrease")
+}
+
+func TestAdmin_EnsureTopicsDefaults(t *testing.T) {
+	cluster := &topicClusterAdmin{topics: map[string]sarama.TopicDetail{}}
+	a := &admin{admin: cluster}
+
+	// The broker defaults are required to create the topic.
+	assert.ErrorContains(t, a.EnsureTopics(TopicSpec{Name: "events"}), "not found")
+
+	cluster.defaults = []sarama.ConfigEntry{{Name: "num.partitions", Value: "6"}, {Name: "default.replication.factor", Value: "3"}}
+	assert.Nil(t, a.EnsureTopics(TopicSpec{Name: "events"}, TopicSpec{Name: "orders", Partitions: 2}))
+	assert.Equal(t, int32(6), cluster.topics["events"].NumPartitions)
+	assert.Equal(t, int16(3), cluster.topics["events"].ReplicationFactor)
+	assert.Equal(t, int32(2), cluster.topics["orders"].NumPartitions)
+	assert.Equal(t, int16(3), cluster.topics["orders"].ReplicationFactor)
+}
+
+func TestAutoCreateTopics(t *testing.T) {
+	cluster := &topicClusterAdmin{topics: map[string]sarama.TopicDetail{}, defaults: []sarama.ConfigEntry{
+		{Name: "num.partitions", Value: "1"}, {Name: "default.replication.factor", Value: "3"},
+	}}
+	broker := NewMockBroker(1)
+	w := broker.NewWriter(WriterInterceptor(AutoCreateTopics(&admin{admin: cluster}, TopicSpec{Partitions: 3})))
+	defer w.Close()
+
+	for i := 0; i < 3; i++ {
+		assert.Nil(t, w.SendMessage(context.Background(), &ProducerMessage{Topic: "backenddot.app-login", Value: []byte("{}")}))
+	}
+	assert.Equal(t, 1, cluster.creates)
+	assert.Equal(t, int32(3), cluster.topics["backenddot.app-login"].NumPartitions)
+	assert.Len(t, broker.Messages("backenddot.app-login"), 3)
+}
@@ -30,17 +30,26 @@ func ToProducerMessage(message *ProducerMessage) (msg *sarama.ProducerMessage) {
 	} else {
 		key = sarama.StringEncoder(message.Key)
 	}
-	if message.Partition <= 0 {
-		message.Partition = int32(-1)
-	}
 	if message.Timestamp.IsZero() {
 		message.Timestamp = now
 	}
 
+	headers := make([]sarama.RecordHeader, 0, len(message.Headers)+1)
+	for _, h := range message.Headers {
+		if string(h.Key) == MessageIDHeader {
+			continue
+		}
+		headers = append(headers, sarama.RecordHeader{Key: h.Key, Value: h.Value})
+	}
+	headers = append(headers, sarama.RecordHeader{Key: []byte(MessageIDHeader), Value: []byte(message.MessageID)})
+
+	// The partition is only honoured by the ManualPartitioner, the other
+	// partitioners overwrite it.
 	return &sarama.ProducerMessage{
 		Topic:     message.Topic,
 		Key:       key,
-		Value:     sarama.StringEncoder(message.Value),
+		Value:     sarama.ByteEncoder(message.Value),
+		Headers:   headers,
 		Metadata:  message.Metadata,
 		Offset:    message.Offset,
 		Partition: message.Partition,
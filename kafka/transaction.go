@@ -0,0 +1,116 @@
+package kafka
+
+import (
+	"io"
+
+	"github.com/Shopify/sarama"
+)
+
+// TxnWriter is a transactional Writer, the messages sent between BeginTxn and
+// Commit are either all visible to ReadCommitted readers or none of them is.
+//
+// A typical consume-transform-produce loop looks like:
+//
+//	_ = w.BeginTxn()
+//	_ = w.SendMessage(ctx, transformed)
+//	_ = w.AddMessageToTxn(consumed, group)
+//	if err := w.Commit(); err != nil {
+//		_ = w.Abort()
+//	}
+type TxnWriter interface {
+	Writer
+	// BeginTxn starts a new transaction.
+	BeginTxn() error
+	// AddOffsetsToTxn commits the consumer group offsets as part of the
+	// current transaction.
+	AddOffsetsToTxn(offsets map[string][]*PartitionOffset, groupID string) error
+	// AddMessageToTxn commits the offset following message as part of the
+	// current transaction.
+	AddMessageToTxn(message *ConsumerMessage, groupID string) error
+	// Commit commits the current transaction.
+	Commit() error
+	// Abort aborts the current transaction.
+	Abort() error
+}
+
+// PartitionOffset is the offset of a consumer group on a partition, it is the
+// offset of the next message to consume.
+type PartitionOffset struct {
+	Partition int32
+	Offset    int64
+	Metadata  string
+}
+
+type txnWriter struct {
+	*writer
+}
+
+// NewTxnWriter creates a transactional writer. The transactionID must be
+// stable across restarts of the same producer instance, so that the broker
+// can fence off zombie instances.
+func NewTxnWriter(brokers []string, transactionID string, opts ...WriterOpt) (TxnWriter, error) {
+	options := newWriterOptions(brokers, opts...)
+	options.Async = false
+	options.Idempotent = true
+	options.TransactionID = transactionID
+
+	w, err := newWriter(options)
+	if err != nil {
+		return nil, err
+	}
+
+	return &txnWriter{writer: w}, nil
+}
+
+// withProducer calls fn with the producer, guarded against a concurrent Close
+// like SendMessage.
+func (w *txnWriter) withProducer(fn func(producer sarama.SyncProducer) error) error {
+	w.mutex.RLock()
+	defer w.mutex.RUnlock()
+
+	if w.isClosed() {
+		return io.ErrClosedPipe
+	}
+	return fn(w.syncProducer)
+}
+
+func (w *txnWriter) BeginTxn() error {
+	return w.withProducer(func(producer sarama.SyncProducer) error {
+		return producer.BeginTxn()
+	})
+}
+
+func (w *txnWriter) AddOffsetsToTxn(offsets map[string][]*PartitionOffset, groupID string) error {
+	txnOffsets := make(map[string][]*sarama.PartitionOffsetMetadata, len(offsets))
+	for topic, partitions := range offsets {
+		for _, p := range partitions {
+			metadata := p.Metadata
+			txnOffsets[topic] = append(txnOffsets[topic], &sarama.PartitionOffsetMetadata{
+				Partition: p.Partition,
+				Offset:    p.Offset,
+				Metadata:  &metadata,
+			})
+		}
+	}
+	return w.withProducer(func(producer sarama.SyncProducer) error {
+		return producer.AddOffsetsToTxn(txnOffsets, groupID)
+	})
+}
+
+func (w *txnWriter) AddMessageToTxn(message *ConsumerMessage, groupID string) error {
+	return w.withProducer(func(producer sarama.SyncProducer) error {
+		return producer.AddMessageToTxn(encodedConsumerMessage(message), groupID, nil)
+	})
+}
+
+func (w *txnWriter) Commit() error {
+	return w.withProducer(func(producer sarama.SyncProducer) error {
+		return producer.CommitTxn()
+	})
+}
+
+func (w *txnWriter) Abort() error {
+	return w.withProducer(func(producer sarama.SyncProducer) error {
+		return producer.AbortTxn()
+	})
+}
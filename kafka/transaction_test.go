@@ -0,0 +1,152 @@
+package kafka
+
+import (
+	"context"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/Shopify/sarama/mocks"
+	"github.com/stretchr/testify/assert"
+)
+
+// txnProducer records the transactional calls made to a mock SyncProducer.
+type txnProducer struct {
+	*mocks.SyncProducer
+
+	mutex   sync.Mutex
+	calls   []string
+	offsets map[string][]*sarama.PartitionOffsetMetadata
+	// begin blocks BeginTxn until closed when set.
+	begin chan struct{}
+}
+
+func (p *txnProducer) record(call string) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.calls = append(p.calls, call)
+}
+
+func (p *txnProducer) BeginTxn() error {
+	p.record("begin")
+	if p.begin != nil {
+		<-p.begin
+	}
+	return p.SyncProducer.BeginTxn()
+}
+
+func (p *txnProducer) SendMessage(msg *sarama.ProducerMessage) (int32, int64, error) {
+	p.record("send")
+	return p.SyncProducer.SendMessage(msg)
+}
+
+func (p *txnProducer) AddOffsetsToTxn(offsets map[string][]*sarama.PartitionOffsetMetadata, groupID string) error {
+	p.record("offsets " + groupID)
+	p.offsets = offsets
+	return p.SyncProducer.AddOffsetsToTxn(offsets, groupID)
+}
+
+func (p *txnProducer) AddMessageToTxn(msg *sarama.ConsumerMessage, groupID string, metadata *string) error {
+	p.record("message " + groupID)
+	return p.SyncProducer.AddMessageToTxn(msg, groupID, metadata)
+}
+
+func (p *txnProducer) CommitTxn() error {
+	p.record("commit")
+	return p.SyncProducer.CommitTxn()
+}
+
+func (p *txnProducer) AbortTxn() error {
+	p.record("abort")
+	return p.SyncProducer.AbortTxn()
+}
+
+func newMockTxnWriter(t *testing.T) (*txnWriter, *txnProducer) {
+	options := newWriterOptions(nil)
+	options.Idempotent = true
+	options.TransactionID = "ledger-1"
+	config, err := newProducerConfig(options)
+	assert.Nil(t, err)
+	producer := &txnProducer{SyncProducer: mocks.NewSyncProducer(t, config)}
+
+	w := allocWriter(options)
+	w.syncProducer = producer
+	return &txnWriter{writer: w}, producer
+}
+
+func TestTxnWriter_Commit(t *testing.T) {
+	w, producer := newMockTxnWriter(t)
+	producer.ExpectSendMessageAndSucceed()
+
+	assert.Nil(t, w.BeginTxn())
+	assert.Equal(t, sarama.ProducerTxnFlagInTransaction, producer.TxnStatus())
+	assert.Nil(t, w.SendMessage(context.Background(), &ProducerMessage{Topic: "ledger", Value: []byte("v")}))
+	assert.Nil(t, w.AddOffsetsToTxn(map[string][]*PartitionOffset{
+		"orders": {{Partition: 1, Offset: 42, Metadata: "md"}},
+	}, "group"))
+	assert.Nil(t, w.Commit())
+	assert.Nil(t, w.Close())
+
+	assert.Equal(t, []string{"begin", "send", "offsets group", "commit"}, producer.calls)
+	assert.Equal(t, sarama.ProducerTxnFlagReady, producer.TxnStatus())
+	offset := producer.offsets["orders"][0]
+	assert.Equal(t, int32(1), offset.Partition)
+	assert.Equal(t, int64(42), offset.Offset)
+	assert.Equal(t, "md", *offset.Metadata)
+}
+
+func TestTxnWriter_Abort(t *testing.T) {
+	w, producer := newMockTxnWriter(t)
+	producer.ExpectSendMessageAndFail(sarama.ErrNotEnoughReplicas)
+
+	assert.Nil(t, w.BeginTxn())
+	err := w.SendMessage(context.Background(), &ProducerMessage{Topic: "ledger", Value: []byte("v")})
+	assert.ErrorIs(t, err, sarama.ErrNotEnoughReplicas)
+	assert.Nil(t, w.Abort())
+	assert.Nil(t, w.Close())
+
+	assert.Equal(t, []string{"begin", "send", "abort"}, producer.calls)
+	assert.Equal(t, sarama.ProducerTxnFlagReady, producer.TxnStatus())
+}
+
+func TestTxnWriter_Closed(t *testing.T) {
+	w, producer := newMockTxnWriter(t)
+	assert.Nil(t, w.Close())
+
+	assert.ErrorIs(t, w.BeginTxn(), io.ErrClosedPipe)
+	assert.ErrorIs(t, w.SendMessage(context.Background(), &ProducerMessage{Topic: "ledger"}), io.ErrClosedPipe)
+	assert.ErrorIs(t, w.AddOffsetsToTxn(map[string][]*PartitionOffset{"orders": {{Offset: 1}}}, "group"), io.ErrClosedPipe)
+	assert.ErrorIs(t, w.AddMessageToTxn(&ConsumerMessage{Topic: "orders"}, "group"), io.ErrClosedPipe)
+	assert.ErrorIs(t, w.Commit(), io.ErrClosedPipe)
+	assert.ErrorIs(t, w.Abort(), io.ErrClosedPipe)
+	assert.Empty(t, producer.calls)
+}
+
+func TestTxnWriter_CloseWaitsForTxn(t *testing.T) {
+	w, producer := newMockTxnWriter(t)
+	producer.begin = make(chan struct{})
+
+	began := make(chan error)
+	go func() { began <- w.BeginTxn() }()
+	assert.Eventually(t, func() bool {
+		producer.mutex.Lock()
+		defer producer.mutex.Unlock()
+		return len(producer.calls) == 1
+	}, time.Second, time.Millisecond)
+
+	closed := make(chan struct{})
+	go func() {
+		assert.Nil(t, w.Close())
+		close(closed)
+	}()
+	select {
+	case <-closed:
+		t.Fatal("Close() returned with an in-flight BeginTxn")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(producer.begin)
+	assert.Nil(t, <-began)
+	<-closed
+}
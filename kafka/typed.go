@@ -0,0 +1,227 @@
+package kafka
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"runtime/debug"
+	"strings"
+
+	"github.com/LabKiko/kiko-gokit/encoding"
+	"github.com/LabKiko/kiko-gokit/encoding/json"
+	_ "github.com/LabKiko/kiko-gokit/encoding/proto"
+	_ "github.com/LabKiko/kiko-gokit/encoding/yaml"
+	"github.com/Shopify/sarama"
+)
+
+// ContentTypeHeader is the header carrying the content type of the value of
+// the messages sent by a TypedWriter, e.g. "application/json".
+const ContentTypeHeader = "content-type"
+
+// DecodeError is passed to the DecodeErrorHandler when the value of a
+// consumed message cannot be decoded.
+type DecodeError struct {
+	ContentType string
+	Err         error
+}
+
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("kafka: decode %q value: %v", e.ContentType, e.Err)
+}
+
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+// DecodeErrorHandler decides what happens to a message whose value cannot be
+// decoded, the returned error is handled like the one of a Handler.
+type DecodeErrorHandler func(ctx context.Context, session sarama.ConsumerGroupSession, message *ConsumerMessage, err error) error
+
+// SkipDecodeError drops the message and marks it as consumed, it is the
+// default DecodeErrorHandler.
+func SkipDecodeError(ctx context.Context, session sarama.ConsumerGroupSession, message *ConsumerMessage, err error) error {
+	session.MarkMessage(encodedConsumerMessage(message), "")
+	return nil
+}
+
+// FailDecodeError returns the error, the message is not marked as consumed.
+func FailDecodeError(ctx context.Context, session sarama.ConsumerGroupSession, message *ConsumerMessage, err error) error {
+	return err
+}
+
+// DeadLetterDecodeError forwards the message unchanged to topic with w and
+// marks it as consumed once the forward succeeded.
+func DeadLetterDecodeError(w Writer, topic string) DecodeErrorHandler {
+	return func(ctx context.Context, session sarama.ConsumerGroupSession, message *ConsumerMessage, err error) error {
+		dead := &ProducerMessage{
+			Topic:     topic,
+			Key:       string(message.Key),
+			Value:     message.Value,
+			MessageID: message.Header(MessageIDHeader),
+		}
+		for _, h := range message.Headers {
+			dead.Headers = append(dead.Headers, &RecordHeader{Key: h.Key, Value: h.Value})
+		}
+		dead.SetHeader("decode-error", err.Error())
+		if err := w.SendMessage(ctx, dead); err != nil {
+			return err
+		}
+		session.MarkMessage(encodedConsumerMessage(message), "")
+		return nil
+	}
+}
+
+type TypedOpts struct {
+	// Codec encodes the values sent and decodes the values of the messages
+	// without ContentTypeHeader, default json.
+	Codec encoding.Codec
+
+	// DecodeErrorHandler handles the messages that cannot be decoded,
+	// default SkipDecodeError.
+	DecodeErrorHandler DecodeErrorHandler
+}
+
+type TypedOpt func(o *TypedOpts)
+
+func newTypedOptions(opts ...TypedOpt) TypedOpts {
+	opt := TypedOpts{
+		Codec:              encoding.GetCodec(json.Name),
+		DecodeErrorHandler: SkipDecodeError,
+	}
+	for _, o := range opts {
+		o(&opt)
+	}
+	return opt
+}
+
+// TypedCodec sets the codec, e.g. encoding.GetCodec("proto").
+func TypedCodec(codec encoding.Codec) TypedOpt {
+	return func(o *TypedOpts) {
+		o.Codec = codec
+	}
+}
+
+// TypedDecodeErrorHandler sets the handler of the messages that cannot be decoded.
+func TypedDecodeErrorHandler(handler DecodeErrorHandler) TypedOpt {
+	return func(o *TypedOpts) {
+		o.DecodeErrorHandler = handler
+	}
+}
+
+// TypedWriter sends values of type T encoded with its codec.
+type TypedWriter[T any] struct {
+	writer Writer
+	opts   TypedOpts
+}
+
+// NewTypedWriter wraps w to send values of type T.
+func NewTypedWriter[T any](w Writer, opts ...TypedOpt) *TypedWriter[T] {
+	return &TypedWriter[T]{writer: w, opts: newTypedOptions(opts...)}
+}
+
+// SendMessage encodes value into the Value of message, sets its
+// ContentTypeHeader and sends it.
+func (w *TypedWriter[T]) SendMessage(ctx context.Context, message *ProducerMessage, value T) error {
+	data, err := w.opts.Codec.Marshal(value)
+	if err != nil {
+		return err
+	}
+	message.Value = data
+	message.SetHeader(ContentTypeHeader, contentType(w.opts.Codec.Name()))
+	return w.writer.SendMessage(ctx, message)
+}
+
+// Send sends value to topic with key.
+func (w *TypedWriter[T]) Send(ctx context.Context, topic, key string, value T) error {
+	return w.SendMessage(ctx, &ProducerMessage{Topic: topic, Key: key}, value)
+}
+
+func (w *TypedWriter[T]) Close() error {
+	return w.writer.Close()
+}
+
+// TypedHandler handles a consumed message and its decoded value.
+type TypedHandler[T any] func(ctx context.Context, session sarama.ConsumerGroupSession, message *ConsumerMessage, value T) error
+
+// TypedReader decodes the values of the consumed messages into T with the
+// codec selected by their ContentTypeHeader.
+type TypedReader[T any] struct {
+	reader Reader
+	opts   TypedOpts
+}
+
+// NewTypedReader wraps r to consume values of type T.
+func NewTypedReader[T any](r Reader, opts ...TypedOpt) *TypedReader[T] {
+	return &TypedReader[T]{reader: r, opts: newTypedOptions(opts...)}
+}
+
+func (r *TypedReader[T]) FetchMessage(ctx context.Context, handler TypedHandler[T]) error {
+	return r.reader.FetchMessage(ctx, func(ctx context.Context, session sarama.ConsumerGroupSession, message *ConsumerMessage) error {
+		value, err := r.decode(message)
+		if err != nil {
+			return r.opts.DecodeErrorHandler(ctx, session, message, err)
+		}
+		return handler(ctx, session, message, value)
+	})
+}
+
+func (r *TypedReader[T]) CommitMessage(ctx context.Context, session sarama.ConsumerGroupSession, message *ConsumerMessage) error {
+	return r.reader.CommitMessage(ctx, session, message)
+}
+
+func (r *TypedReader[T]) Pause(topic string, partitions ...int32) {
+	r.reader.Pause(topic, partitions...)
+}
+
+func (r *TypedReader[T]) Resume(topic string, partitions ...int32) {
+	r.reader.Resume(topic, partitions...)
+}
+
+func (r *TypedReader[T]) Close() error {
+	return r.reader.Close()
+}
+
+// decode unmarshals the value of message, a panicking codec is reported as
+// a DecodeError.
+func (r *TypedReader[T]) decode(message *ConsumerMessage) (value T, err error) {
+	ct := message.Header(ContentTypeHeader)
+	codec := r.opts.Codec
+	if ct != "" {
+		if codec = encoding.GetCodec(contentSubtype(ct)); codec == nil {
+			return value, &DecodeError{ContentType: ct, Err: fmt.Errorf("no codec registered for %s", contentSubtype(ct))}
+		}
+	}
+
+	defer func() {
+		if p := recover(); p != nil {
+			err = &DecodeError{ContentType: ct, Err: fmt.Errorf("panic: %v\n%s", p, debug.Stack())}
+		}
+	}()
+	// Pointer types such as proto messages are decoded into a new value
+	// rather than into a pointer to a nil pointer.
+	target := interface{}(&value)
+	if t := reflect.TypeOf(value); t != nil && t.Kind() == reflect.Ptr {
+		ptr := reflect.New(t.Elem()).Interface()
+		value, target = ptr.(T), ptr
+	}
+	if err = codec.Unmarshal(message.Value, target); err != nil {
+		return value, &DecodeError{ContentType: ct, Err: err}
+	}
+	return value, nil
+}
+
+func contentType(subtype string) string {
+	return "application/" + subtype
+}
+
+// contentSubtype returns the codec name of a content type, e.g. "json" for
+// "application/json; charset=utf-8".
+func contentSubtype(contentType string) string {
+	if i := strings.Index(contentType, ";"); i >= 0 {
+		contentType = contentType[:i]
+	}
+	if i := strings.LastIndex(contentType, "/"); i >= 0 {
+		contentType = contentType[i+1:]
+	}
+	return strings.ToLower(strings.TrimSpace(contentType))
+}
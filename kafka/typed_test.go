@@ -0,0 +1,124 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/LabKiko/kiko-gokit/encoding"
+	"github.com/Shopify/sarama"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+type order struct {
+	ID     string `json:"id" yaml:"id"`
+	Amount int    `json:"amount" yaml:"amount"`
+}
+
+func TestTyped_Codecs(t *testing.T) {
+	broker := NewMockBroker(1)
+	w := broker.NewWriter()
+	defer w.Close()
+
+	// The reader defaults to json but follows the content type of every message.
+	_ = NewTypedWriter[order](w).Send(context.Background(), "orders", "1", order{ID: "1", Amount: 10})
+	_ = NewTypedWriter[order](w, TypedCodec(encoding.GetCodec("yaml"))).Send(context.Background(), "orders", "2", order{ID: "2", Amount: 20})
+
+	var orders []order
+	r := NewTypedReader[order](broker.NewReader("orders", "g"))
+	defer r.Close()
+	_ = r.FetchMessage(context.Background(), func(ctx context.Context, session sarama.ConsumerGroupSession, message *ConsumerMessage, value order) error {
+		orders = append(orders, value)
+		return nil
+	})
+	waitIdle(t, broker)
+
+	if len(orders) != 2 || orders[0] != (order{ID: "1", Amount: 10}) || orders[1] != (order{ID: "2", Amount: 20}) {
+		t.Errorf("orders = %+v", orders)
+	}
+	if ct := broker.Messages("orders")[1].Header(ContentTypeHeader); ct != "application/yaml" {
+		t.Errorf("content type = %q", ct)
+	}
+}
+
+func TestTyped_Proto(t *testing.T) {
+	broker := NewMockBroker(1)
+	w := broker.NewWriter()
+	defer w.Close()
+
+	proto := TypedCodec(encoding.GetCodec("proto"))
+	if err := NewTypedWriter[*wrapperspb.StringValue](w, proto).Send(context.Background(), "names", "", wrapperspb.String("kiko")); err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+
+	var got string
+	r := NewTypedReader[*wrapperspb.StringValue](broker.NewReader("names", "g"), proto)
+	defer r.Close()
+	_ = r.FetchMessage(context.Background(), func(ctx context.Context, session sarama.ConsumerGroupSession, message *ConsumerMessage, value *wrapperspb.StringValue) error {
+		got = value.GetValue()
+		return nil
+	})
+	waitIdle(t, broker)
+
+	if got != "kiko" {
+		t.Errorf("value = %q, want kiko", got)
+	}
+}
+
+func TestTyped_DecodeError(t *testing.T) {
+	broker := NewMockBroker(1)
+	w := broker.NewWriter()
+	defer w.Close()
+
+	bad := &ProducerMessage{Topic: "orders", Value: []byte("{")}
+	bad.SetHeader(ContentTypeHeader, "application/json")
+	_ = w.SendMessage(context.Background(), bad)
+	unknown := &ProducerMessage{Topic: "orders", Value: []byte("a")}
+	unknown.SetHeader(ContentTypeHeader, "application/avro")
+	_ = w.SendMessage(context.Background(), unknown)
+	_ = NewTypedWriter[order](w).Send(context.Background(), "orders", "", order{ID: "ok"})
+
+	var (
+		decodeErrs []error
+		orders     []order
+	)
+	r := NewTypedReader[order](broker.NewReader("orders", "g"), TypedDecodeErrorHandler(
+		func(ctx context.Context, session sarama.ConsumerGroupSession, message *ConsumerMessage, err error) error {
+			decodeErrs = append(decodeErrs, err)
+			return DeadLetterDecodeError(w, "orders.dead")(ctx, session, message, err)
+		}))
+	defer r.Close()
+	_ = r.FetchMessage(context.Background(), func(ctx context.Context, session sarama.ConsumerGroupSession, message *ConsumerMessage, value order) error {
+		orders = append(orders, value)
+		return r.CommitMessage(ctx, session, message)
+	})
+	waitIdle(t, broker)
+
+	if len(orders) != 1 || orders[0].ID != "ok" {
+		t.Errorf("orders = %+v", orders)
+	}
+	var decodeErr *DecodeError
+	if len(decodeErrs) != 2 || !errors.As(decodeErrs[0], &decodeErr) || !errors.As(decodeErrs[1], &decodeErr) {
+		t.Fatalf("decode errors = %v", decodeErrs)
+	}
+	dead := broker.Messages("orders.dead")
+	if len(dead) != 2 || string(dead[1].Value) != "a" || dead[1].Header(ContentTypeHeader) != "application/avro" || dead[1].Header("decode-error") == "" {
+		t.Errorf("dead letters = %+v", dead)
+	}
+	if got := broker.Committed("g", "orders", 0); got != 3 {
+		t.Errorf("Committed() = %d, want 3", got)
+	}
+}
+
+func TestContentSubtype(t *testing.T) {
+	for contentType, want := range map[string]string{
+		"application/json":                "json",
+		"application/x-protobuf":          "x-protobuf",
+		"Application/YAML; charset=utf-8": "yaml",
+		"proto":                           "proto",
+	} {
+		if got := contentSubtype(contentType); got != want {
+			t.Errorf("contentSubtype(%q) = %q, want %q", contentType, got, want)
+		}
+	}
+}
@@ -12,7 +12,6 @@ import (
 	"errors"
 	"fmt"
 	"io"
-	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -36,109 +35,187 @@ type writer struct {
 
 	// Atomic flag indicating whether the writer has been closed.
 	closed   uint32
-	close    chan bool
+	done     chan struct{}
 	errors   chan *ProducerError
 	messages chan *ProducerMessage
+	// send produces a message through the interceptors.
+	send SendFunc
 
-	// Manages the current batch being aggregated on the writer.
-	mutex         sync.Mutex
+	// Guards the producer input against a concurrent Close.
+	mutex         sync.RWMutex
 	syncProducer  sarama.SyncProducer
 	asyncProducer sarama.AsyncProducer
+
+	// unregisterLogs disables the sarama debug logs enabled by the writer.
+	unregisterLogs func()
 }
 
 // NewWriter 初始化
 func NewWriter(brokers []string, opts ...WriterOpt) (Writer, error) {
+	return newWriter(newWriterOptions(brokers, opts...))
+}
+
+func newWriter(options WriterOpts) (*writer, error) {
 	var (
 		err error
 	)
 
-	options := newWriterOptions(brokers, opts...)
-	config := sarama.NewConfig()
-	config.ClientID = options.ServiceName
-	config.Net.KeepAlive = 60 * time.Second
-	config.Producer.RequiredAcks = sarama.RequiredAcks(options.RequiredAck)
-	config.Producer.Return.Errors = true
-	config.Producer.Return.Successes = true
-	config.Producer.Partitioner = sarama.NewRandomPartitioner
-	config.Producer.MaxMessageBytes = int(sarama.MaxRequestSize - 1)
-	config.Version = sarama.V3_0_0_0
+	config, err := newProducerConfig(options)
+	if err != nil {
+		return nil, err
+	}
+	w := allocWriter(options)
+	w.unregisterLogs = options.registerLogs()
+
+	// 异步配置
+	if options.Async {
+		var producer sarama.AsyncProducer
+		if producer, err = sarama.NewAsyncProducer(options.Brokers, config); err != nil {
+			w.unregisterLogs()
+			return nil, err
+		}
+		w.asyncProducer = otelsarama.WrapAsyncProducer(config, producer)
+		go w.eventNotification()
+	} else {
+		var producer sarama.SyncProducer
+		if producer, err = sarama.NewSyncProducer(options.Brokers, config); err != nil {
+			w.unregisterLogs()
+			return nil, err
+		}
+		w.syncProducer = otelsarama.WrapSyncProducer(config, producer)
+	}
+
+	return w, nil
+}
+
+func allocWriter(options WriterOpts) *writer {
 	w := &writer{
 		opts:     options,
-		close:    make(chan bool),
+		done:     make(chan struct{}),
 		errors:   make(chan *ProducerError),
 		messages: make(chan *ProducerMessage),
 	}
+	w.send = ChainInterceptors(options.Interceptors...)(w.produce)
+	return w
+}
 
-	// 异步配置
+func newProducerConfig(options WriterOpts) (*sarama.Config, error) {
+	config, err := newClientConfig(options.ServiceName, options.ClientOpts)
+	if err != nil {
+		return nil, err
+	}
+	config.Net.KeepAlive = 60 * time.Second
+	config.Producer.RequiredAcks = sarama.RequiredAcks(options.RequiredAck)
+	config.Producer.Return.Errors = true
+	config.Producer.Return.Successes = true
+	config.Producer.Partitioner = options.Partitioner.constructor()
+	config.Producer.MaxMessageBytes = options.MaxMessageBytes
 	if options.Async {
 		config.Producer.Retry.Max = options.MaxAttempts
-		w.asyncProducer, err = sarama.NewAsyncProducer(options.Brokers, config)
-		w.asyncProducer = otelsarama.WrapAsyncProducer(config, w.asyncProducer)
 	} else {
 		config.Producer.Timeout = 5 * time.Second
 		if v := options.ReadTimeout; v > 0 {
 			config.Producer.Timeout = time.Duration(v) * time.Second
 		}
-		w.syncProducer, err = sarama.NewSyncProducer(options.Brokers, config)
-		w.syncProducer = otelsarama.WrapSyncProducer(config, w.syncProducer)
 	}
-	if err != nil {
-		return nil, err
+
+	// 幂等生产者要求 acks=all, 单连接单请求并且至少重试一次
+	if options.Idempotent || options.TransactionID != "" {
+		config.Producer.Idempotent = true
+		config.Producer.RequiredAcks = sarama.WaitForAll
+		config.Net.MaxOpenRequests = 1
+		if config.Producer.Retry.Max < 1 {
+			config.Producer.Retry.Max = 1
+		}
 	}
-	if options.Async {
-		go w.eventNotification()
+	if options.TransactionID != "" {
+		config.Producer.Transaction.ID = options.TransactionID
 	}
 
-	return w, nil
+	config.Producer.Compression = options.Compression.codec()
+	if options.FlushBytes > 0 {
+		config.Producer.Flush.Bytes = options.FlushBytes
+	}
+	if options.FlushMessages > 0 {
+		config.Producer.Flush.Messages = options.FlushMessages
+	}
+	if options.FlushFrequency > 0 {
+		config.Producer.Flush.Frequency = options.FlushFrequency
+	}
+	if options.ConfigFunc != nil {
+		options.ConfigFunc(config)
+	}
+
+	return config, nil
 }
 
+// eventNotification consumes the delivery reports of the async producer until
+// both of its channels are closed, then closes the writer channels.
 func (w *writer) eventNotification() {
-	for {
+	defer func() {
+		close(w.errors)
+		close(w.messages)
+		close(w.done)
+	}()
+
+	errs, successes := w.asyncProducer.Errors(), w.asyncProducer.Successes()
+	for errs != nil || successes != nil {
 		select {
-		case <-w.close:
-			goto close
-
-		case err, ok := <-w.asyncProducer.Errors():
+		case err, ok := <-errs:
 			if !ok {
-				goto close
+				errs = nil
+				continue
 			}
 
 			body, _ := err.Msg.Value.Encode()
-			headers := make(map[string]string)
-			for _, v := range err.Msg.Headers {
-				headers[strings.ToLower(string(v.Key))] = string(v.Value)
-			}
-
 			w.opts.Logger.WithFields(map[string]interface{}{
 				"topic":     err.Msg.Topic,
 				"offset":    err.Msg.Offset,
 				"partition": err.Msg.Partition,
 				"body":      string(body),
-				"header":    headers,
+				"header":    headersToMap(err.Msg.Headers),
 			}).Errorf("producerError: %v", err.Err)
 
-		case msg, ok := <-w.asyncProducer.Successes():
+			message, sentAt := producerMessageFromReport(err.Msg)
+			w.report(message, sentAt, err.Err)
+
+		case msg, ok := <-successes:
 			if !ok {
-				goto close
+				successes = nil
+				continue
 			}
 
 			body, _ := msg.Value.Encode()
-			headers := make(map[string]string)
-			for _, v := range msg.Headers {
-				headers[strings.ToLower(string(v.Key))] = string(v.Value)
-			}
-
 			w.opts.Logger.WithFields(map[string]interface{}{
 				"topic":     msg.Topic,
 				"offset":    msg.Offset,
 				"partition": msg.Partition,
 				"body":      string(body),
-				"header":    headers,
+				"header":    headersToMap(msg.Headers),
 			}).Debug("send msg success")
+
+			message, sentAt := producerMessageFromReport(msg)
+			w.report(message, sentAt, nil)
 		}
 	}
+}
 
-close:
+// report records the delivery report of a message and passes it to the
+// callback and, if enabled, to the Messages or Errors channel.
+func (w *writer) report(message *ProducerMessage, sentAt time.Time, err error) {
+	w.opts.Metrics.observeSend(message.Topic, len(message.Value), time.Since(sentAt), err)
+	if w.opts.Callback != nil {
+		w.opts.Callback(message, err)
+	}
+	if !w.opts.Async {
+		return
+	}
+	if err != nil && w.opts.ReturnErrors {
+		w.errors <- &ProducerError{Msg: message, Err: err}
+	}
+	if err == nil && w.opts.ReturnSuccesses {
+		w.messages <- message
+	}
 }
 
 func (w *writer) SendMessage(ctx context.Context, message *ProducerMessage) (err error) {
@@ -146,6 +223,9 @@ func (w *writer) SendMessage(ctx context.Context, message *ProducerMessage) (err
 		span trace.Span
 	)
 
+	w.mutex.RLock()
+	defer w.mutex.RUnlock()
+
 	if w.isClosed() {
 		return io.ErrClosedPipe
 	}
@@ -154,17 +234,7 @@ func (w *writer) SendMessage(ctx context.Context, message *ProducerMessage) (err
 	ctx, span = tr.Start(ctx, fmt.Sprintf("KF Producer %s", message.Topic))
 	defer span.End()
 
-	msg := ToProducerMessage(message)
-
-	tr.Inject(ctx, otelsarama.NewProducerMessageCarrier(msg))
-
-	if w.opts.Async {
-		err = w.asyncSend(ctx, msg)
-	} else {
-		_, _, err = w.syncSend(ctx, msg)
-	}
-
-	if err != nil {
+	if err = w.send(ctx, message); err != nil {
 		span.RecordError(err)
 		span.SetStatus(codes.Error, err.Error())
 		return err
@@ -173,6 +243,25 @@ func (w *writer) SendMessage(ctx context.Context, message *ProducerMessage) (err
 	return err
 }
 
+// produce converts message and hands it over to the producer, it is the
+// innermost SendFunc of the interceptors.
+func (w *writer) produce(ctx context.Context, message *ProducerMessage) (err error) {
+	sentAt := time.Now()
+	msg := ToProducerMessage(message)
+	// Keep the original message so that delivery reports carry its MessageID
+	// and Metadata, sarama passes this field through untouched.
+	msg.Metadata = &pendingMessage{message: message, sentAt: sentAt}
+
+	tracex.NewTracer(trace.SpanKindProducer).Inject(ctx, otelsarama.NewProducerMessageCarrier(msg))
+
+	if w.opts.Async {
+		return w.asyncSend(ctx, msg)
+	}
+	message.Partition, message.Offset, err = w.syncSend(ctx, msg)
+	w.report(message, sentAt, err)
+	return err
+}
+
 func (w *writer) syncSend(crx context.Context, msg *sarama.ProducerMessage) (partition int32, offset int64, err error) {
 	if w.syncProducer == nil {
 		return 0, 0, errors.New("kafka sync producer client not init")
@@ -198,33 +287,47 @@ func (w *writer) isClosed() bool {
 	return atomic.LoadUint32(&w.closed) != 0
 }
 
-// Errors returns a read channel of errors that occur during offset management, if
-// enabled. By default, errors are logged and not returned over this channel. If
-// you want to implement any custom error handling, set your config's
-// Consumer.Return.Errors setting to true, and read from this channel.
+// Errors returns a read channel of the messages that the async producer failed
+// to deliver. By default, errors are only logged and not returned over this
+// channel. If you want to implement any custom error handling, set the
+// WriterReturnErrors option and read from this channel, otherwise the writer
+// will deadlock.
 func (w *writer) Errors() <-chan *ProducerError { return w.errors }
 
+// Messages returns a read channel of the messages that the async producer
+// delivered successfully. It is only written to when the WriterReturnSuccesses
+// option is set, in which case you MUST read from it.
 func (w *writer) Messages() <-chan *ProducerMessage { return w.messages }
 
+// Close stops accepting new messages, waits for the in-flight messages to be
+// delivered or to fail and then closes the Errors and Messages channels.
 func (w *writer) Close() (err error) {
+	w.mutex.Lock()
 	if w.isClosed() {
+		w.mutex.Unlock()
 		return
 	}
+	w.markClosed()
+	w.mutex.Unlock()
 
-	close(w.close)
-	close(w.errors)
-	close(w.messages)
 	if w.opts.Async {
-		err = w.asyncProducer.Close()
+		// AsyncClose lets the delivery reports of the in-flight messages flow
+		// through eventNotification, which closes the channels once drained.
+		w.asyncProducer.AsyncClose()
+		<-w.done
 	} else {
 		err = w.syncProducer.Close()
+		close(w.errors)
+		close(w.messages)
+		close(w.done)
+	}
+	if w.unregisterLogs != nil {
+		w.unregisterLogs()
 	}
 	if err != nil {
 		return err
 	}
 
-	w.markClosed()
-
 	w.opts.Logger.Infof("closed success")
 	return nil
 }
@@ -0,0 +1,100 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/Shopify/sarama/mocks"
+	"github.com/stretchr/testify/assert"
+)
+
+func newMockAsyncWriter(t *testing.T, opts ...WriterOpt) (*writer, *mocks.AsyncProducer) {
+	config := sarama.NewConfig()
+	config.Producer.Return.Successes = true
+	config.Producer.Return.Errors = true
+	producer := mocks.NewAsyncProducer(t, config)
+
+	w := allocWriter(newWriterOptions(nil, append([]WriterOpt{WriterAsync(true)}, opts...)...))
+	w.asyncProducer = producer
+	go w.eventNotification()
+	return w, producer
+}
+
+func TestWriter_DeliveryReports(t *testing.T) {
+	var callbacks int
+	w, producer := newMockAsyncWriter(t,
+		WriterReturnSuccesses(true),
+		WriterReturnErrors(true),
+		WriterCallback(func(message *ProducerMessage, err error) {
+			callbacks++
+		}),
+	)
+	producer.ExpectInputAndSucceed()
+	producer.ExpectInputAndFail(sarama.ErrOutOfBrokers)
+
+	ok := &ProducerMessage{Topic: "test", Value: []byte("ok"), MessageID: "id-1", Metadata: "md-1"}
+	assert.Nil(t, w.SendMessage(context.Background(), ok))
+	msg := <-w.Messages()
+	assert.Equal(t, "id-1", msg.MessageID)
+	assert.Equal(t, "md-1", msg.Metadata)
+
+	failed := &ProducerMessage{Topic: "test", Value: []byte("failed"), MessageID: "id-2"}
+	assert.Nil(t, w.SendMessage(context.Background(), failed))
+	perr := <-w.Errors()
+	assert.Equal(t, "id-2", perr.Msg.MessageID)
+	assert.True(t, errors.Is(perr.Err, sarama.ErrOutOfBrokers))
+
+	assert.Nil(t, w.Close())
+	_, open := <-w.Messages()
+	assert.False(t, open)
+	_, open = <-w.Errors()
+	assert.False(t, open)
+	assert.Equal(t, 2, callbacks)
+	assert.ErrorIs(t, w.SendMessage(context.Background(), ok), io.ErrClosedPipe)
+}
+
+func TestWriter_CloseDrainsInFlight(t *testing.T) {
+	var delivered int
+	w, producer := newMockAsyncWriter(t, WriterCallback(func(message *ProducerMessage, err error) {
+		delivered++
+	}))
+	for i := 0; i < 10; i++ {
+		producer.ExpectInputAndSucceed()
+		assert.Nil(t, w.SendMessage(context.Background(), &ProducerMessage{Topic: "test", Value: []byte("v")}))
+	}
+
+	assert.Nil(t, w.Close())
+	assert.Equal(t, 10, delivered)
+}
+
+func TestNewProducerConfig_Transactional(t *testing.T) {
+	options := newWriterOptions(nil, WriterRequiredAck(WaitForLocal))
+	options.TransactionID = "ledger-1"
+	config, err := newProducerConfig(options)
+	assert.Nil(t, err)
+	assert.Nil(t, config.Validate())
+	assert.True(t, config.Producer.Idempotent)
+	assert.Equal(t, sarama.WaitForAll, config.Producer.RequiredAcks)
+	assert.Equal(t, "ledger-1", config.Producer.Transaction.ID)
+}
+
+func TestNewProducerConfig_Tuning(t *testing.T) {
+	config, err := newProducerConfig(newWriterOptions(nil,
+		WriterAsync(true),
+		WriterCompression(CompressionZSTD),
+		WriterFlush(64<<10, 100, 10*time.Millisecond),
+		WriterSaramaConfig(func(config *sarama.Config) {
+			config.Producer.CompressionLevel = 3
+		}),
+	))
+	assert.Nil(t, err)
+	assert.Nil(t, config.Validate())
+	assert.Equal(t, sarama.CompressionZSTD, config.Producer.Compression)
+	assert.Equal(t, 3, config.Producer.CompressionLevel)
+	assert.Equal(t, 100, config.Producer.Flush.Messages)
+	assert.Equal(t, 10*time.Millisecond, config.Producer.Flush.Frequency)
+}